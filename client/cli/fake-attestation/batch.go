@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultBatchWorkers = 4
+
+// batchPrompt is one input line of the batch subcommand.
+type batchPrompt struct {
+	ID      string          `json:"id"`
+	Model   string          `json:"model"`
+	Prompt  string          `json:"prompt"`
+	Options json.RawMessage `json:"options,omitempty"`
+}
+
+// batchResult is one output line of the batch subcommand.
+type batchResult struct {
+	ID         string `json:"id"`
+	Model      string `json:"model"`
+	Status     string `json:"status"` // "ok" or "error"
+	HTTPStatus int    `json:"http_status,omitempty"`
+	LatencyMS  int64  `json:"latency_ms"`
+	Response   string `json:"response,omitempty"`
+	EvalCount  int    `json:"eval_count,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type batchOptions struct {
+	Input   string
+	Output  string
+	Workers int
+	Resume  bool
+}
+
+func parseBatchOptions(args []string) (batchOptions, error) {
+	opts := batchOptions{Input: "-", Workers: defaultBatchWorkers}
+	if value, found, err := findStringFlag(args, "input"); err != nil {
+		return opts, err
+	} else if found {
+		opts.Input = value
+	}
+	if value, found, err := findStringFlag(args, "output"); err != nil {
+		return opts, err
+	} else if found {
+		opts.Output = value
+	}
+	if value, found, err := findStringFlag(args, "workers"); err != nil {
+		return opts, err
+	} else if found {
+		workers, err := strconv.Atoi(value)
+		if err != nil || workers < 1 {
+			return opts, fmt.Errorf("invalid -workers value %q (use a positive integer)", value)
+		}
+		opts.Workers = workers
+	}
+	resume, err := findBoolFlag(args, "resume")
+	if err != nil {
+		return opts, err
+	}
+	opts.Resume = resume
+	if opts.Resume && (opts.Output == "" || opts.Output == "-") {
+		return opts, errors.New("-resume needs -output to name the results file")
+	}
+	return opts, nil
+}
+
+// readBatchPrompts reads the input JSONL. Lines without an id are named after
+// their line number, which stays stable across resumed runs of the same file.
+func readBatchPrompts(r io.Reader, defaultModel string) ([]batchPrompt, error) {
+	var prompts []batchPrompt
+	seen := map[string]int{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxStreamLineBytes)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var prompt batchPrompt
+		if err := json.Unmarshal([]byte(line), &prompt); err != nil {
+			return nil, fmt.Errorf("line %d: invalid JSON: %w", lineNo, err)
+		}
+		if strings.TrimSpace(prompt.Prompt) == "" {
+			return nil, fmt.Errorf("line %d: prompt is required", lineNo)
+		}
+		if prompt.ID == "" {
+			prompt.ID = fmt.Sprintf("line-%d", lineNo)
+		}
+		if first, ok := seen[prompt.ID]; ok {
+			return nil, fmt.Errorf("line %d: id %q is already used on line %d", lineNo, prompt.ID, first)
+		}
+		seen[prompt.ID] = lineNo
+		prompt.Model = firstNonEmpty(strings.TrimSpace(prompt.Model), defaultModel)
+		prompts = append(prompts, prompt)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return prompts, nil
+}
+
+// completedBatchIDs returns the ids that already have an "ok" result in
+// path. Failed prompts are run again.
+func completedBatchIDs(path string) (map[string]bool, error) {
+	done := map[string]bool{}
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return done, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxStreamLineBytes)
+	for scanner.Scan() {
+		var result batchResult
+		// A run killed mid-write can leave a torn last line; skip it.
+		if json.Unmarshal(scanner.Bytes(), &result) == nil && result.Status == "ok" {
+			done[result.ID] = true
+		}
+	}
+	return done, scanner.Err()
+}
+
+// openBatchOutput opens the results file. Without -resume an existing,
+// non-empty file is refused rather than overwritten.
+func openBatchOutput(path string, resume bool) (io.WriteCloser, error) {
+	if path == "" || path == "-" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	if !resume {
+		if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+			return nil, fmt.Errorf("%s already has results (use -resume to continue it)", path)
+		}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	// Start on a fresh line if the previous run was cut off mid-line.
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			if _, err := file.Write([]byte("\n")); err != nil {
+				file.Close()
+				return nil, err
+			}
+		}
+	}
+	return file, nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// runBatch sends every prompt that has no result yet through client with
+// opts.Workers requests in flight, and writes one result line per prompt as
+// soon as it completes. On interrupt, in-flight prompts are abandoned without
+// a result line, so a resumed run picks them up again.
+func runBatch(client *refreshingClient, badges *badgeStore, model string, opts batchOptions) (int, error) {
+	input := io.Reader(os.Stdin)
+	if opts.Input != "-" {
+		file, err := os.Open(opts.Input)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+		input = file
+	}
+	prompts, err := readBatchPrompts(input, model)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", opts.Input, err)
+	}
+
+	done := map[string]bool{}
+	if opts.Resume {
+		if done, err = completedBatchIDs(opts.Output); err != nil {
+			return 0, fmt.Errorf("failed to read %s: %w", opts.Output, err)
+		}
+	}
+	pending := make([]batchPrompt, 0, len(prompts))
+	for _, prompt := range prompts {
+		if done[prompt.ID] {
+			continue
+		}
+		if err := badges.allow(prompt.Model); err != nil {
+			return 0, err
+		}
+		pending = append(pending, prompt)
+	}
+
+	output, err := openBatchOutput(opts.Output, opts.Resume)
+	if err != nil {
+		return 0, err
+	}
+	defer output.Close()
+
+	fmt.Fprintf(os.Stderr, "Batch: %d prompt(s), %d already done, %d to run with %d worker(s)\n",
+		len(prompts), len(prompts)-len(pending), len(pending), opts.Workers)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	jobs := make(chan batchPrompt)
+	results := make(chan batchResult)
+	var wg sync.WaitGroup
+	for range opts.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for prompt := range jobs {
+				result := sendBatchPrompt(ctx, client, prompt)
+				if ctx.Err() != nil {
+					continue
+				}
+				results <- result
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, prompt := range pending {
+			select {
+			case jobs <- prompt:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	started := time.Now()
+	var ok, failed int
+	var writeErr error
+	encoder := json.NewEncoder(output)
+	for result := range results {
+		if result.Status == "ok" {
+			ok++
+		} else {
+			failed++
+		}
+		if writeErr == nil {
+			writeErr = encoder.Encode(result)
+		}
+	}
+	if writeErr != nil {
+		return failed, fmt.Errorf("failed to write results: %w", writeErr)
+	}
+
+	fmt.Fprintf(os.Stderr, "Batch finished in %s: %d ok, %d failed, %d skipped\n",
+		time.Since(started).Round(time.Millisecond), ok, failed, len(prompts)-len(pending))
+	if ctx.Err() != nil {
+		return failed, fmt.Errorf("interrupted with %d prompt(s) left; rerun with -resume to continue", len(pending)-ok-failed)
+	}
+	return failed, nil
+}
+
+func sendBatchPrompt(ctx context.Context, client *refreshingClient, prompt batchPrompt) batchResult {
+	result := batchResult{ID: prompt.ID, Model: prompt.Model, Status: "error"}
+	body := map[string]any{
+		"model":  prompt.Model,
+		"prompt": prompt.Prompt,
+		"stream": false,
+	}
+	if len(prompt.Options) > 0 {
+		body["options"] = prompt.Options
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://confsec.invalid/api/generate", bytes.NewReader(payload))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Confsec-Node-Tags", "model="+prompt.Model)
+
+	started := time.Now()
+	resp, err := client.RoundTrip(req)
+	if err != nil {
+		result.LatencyMS = time.Since(started).Milliseconds()
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	result.LatencyMS = time.Since(started).Milliseconds()
+	result.HTTPStatus = resp.StatusCode
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to read response: %v", err)
+		return result
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		result.Error = fmt.Sprintf("non-OK response: %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+		return result
+	}
+
+	var chunk generateChunk
+	if err := json.Unmarshal(respBody, &chunk); err != nil {
+		result.Error = fmt.Sprintf("invalid response JSON: %v", err)
+		return result
+	}
+	if chunk.Error != "" {
+		result.Error = fmt.Sprintf("server error: %s", chunk.Error)
+		return result
+	}
+	result.Status = "ok"
+	result.Response = chunk.Response
+	result.EvalCount = chunk.EvalCount
+	return result
+}
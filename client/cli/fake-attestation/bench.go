@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	defaultBenchDuration    = 30 * time.Second
+	defaultBenchConcurrency = 4
+	defaultBenchWarmup      = 1
+)
+
+type benchOptions struct {
+	Duration    time.Duration
+	Concurrency int
+	Rate        float64
+	Warmup      int
+	JSONPath    string
+}
+
+func parseBenchOptions(args []string) (benchOptions, error) {
+	opts := benchOptions{
+		Duration:    defaultBenchDuration,
+		Concurrency: defaultBenchConcurrency,
+		Warmup:      defaultBenchWarmup,
+	}
+	if value, found, err := findStringFlag(args, "duration"); err != nil {
+		return opts, err
+	} else if found {
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			return opts, fmt.Errorf("invalid -duration value %q (use e.g. 30s or 2m)", value)
+		}
+		opts.Duration = duration
+	}
+	if value, found, err := findStringFlag(args, "concurrency"); err != nil {
+		return opts, err
+	} else if found {
+		concurrency, err := strconv.Atoi(value)
+		if err != nil || concurrency < 1 {
+			return opts, fmt.Errorf("invalid -concurrency value %q (use a positive integer)", value)
+		}
+		opts.Concurrency = concurrency
+	}
+	if value, found, err := findStringFlag(args, "rate"); err != nil {
+		return opts, err
+	} else if found {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate <= 0 {
+			return opts, fmt.Errorf("invalid -rate value %q (use requests per second, e.g. 2 or 0.5)", value)
+		}
+		opts.Rate = rate
+	}
+	if value, found, err := findStringFlag(args, "warmup"); err != nil {
+		return opts, err
+	} else if found {
+		warmup, err := strconv.Atoi(value)
+		if err != nil || warmup < 0 {
+			return opts, fmt.Errorf("invalid -warmup value %q (use 0 or more requests)", value)
+		}
+		opts.Warmup = warmup
+	}
+	if value, found, err := findStringFlag(args, "json"); err != nil {
+		return opts, err
+	} else if found {
+		opts.JSONPath = value
+	}
+	return opts, nil
+}
+
+// benchLatency summarizes a latency distribution in milliseconds.
+type benchLatency struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// benchReport is the result of one bench run. It records the setup next to
+// the numbers, so reports from different releases or modes can be compared.
+type benchReport struct {
+	Model           string         `json:"model"`
+	OHTTP           bool           `json:"ohttp"`
+	Attestation     string         `json:"attestation"`
+	Mode            string         `json:"mode"` // "concurrency" or "rate"
+	Concurrency     int            `json:"concurrency"`
+	Rate            float64        `json:"rate,omitempty"`
+	StartedAt       time.Time      `json:"started_at"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	Requests        int            `json:"requests"`
+	Succeeded       int            `json:"succeeded"`
+	Failed          int            `json:"failed"`
+	Dropped         int            `json:"dropped,omitempty"`
+	ThroughputRPS   float64        `json:"throughput_rps"`
+	TokensPerSecond float64        `json:"tokens_per_second"`
+	Latency         benchLatency   `json:"latency_ms"`
+	TTFB            benchLatency   `json:"ttfb_ms"`
+	Errors          map[string]int `json:"errors"`
+}
+
+type benchSample struct {
+	latency time.Duration
+	ttfb    time.Duration
+	tokens  int
+	errKind string
+}
+
+// firstByteReader records when the first response byte was read.
+type firstByteReader struct {
+	r     io.Reader
+	first time.Time
+}
+
+func (f *firstByteReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if n > 0 && f.first.IsZero() {
+		f.first = time.Now()
+	}
+	return n, err
+}
+
+// runBench sends streaming /api/generate requests for opts.Duration. Without
+// -rate, opts.Concurrency workers send back to back. With -rate, a request
+// is started at that rate, and a start is dropped when opts.Concurrency
+// requests are already in flight. Requests still in flight at the end are
+// waited for and counted.
+func runBench(client *refreshingClient, model, prompt string, opts benchOptions, report benchReport) (benchReport, error) {
+	payload, err := json.Marshal(map[string]any{
+		"model":  model,
+		"prompt": prompt,
+		"stream": true,
+	})
+	if err != nil {
+		return report, err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	for idx := range opts.Warmup {
+		sample := sendBenchRequest(ctx, client, model, payload)
+		if sample.errKind != "" {
+			return report, fmt.Errorf("warmup request %d failed (%s)", idx+1, sample.errKind)
+		}
+	}
+
+	report.Model = model
+	report.Concurrency = opts.Concurrency
+	report.Rate = opts.Rate
+	report.Mode = "concurrency"
+	if opts.Rate > 0 {
+		report.Mode = "rate"
+	}
+	fmt.Fprintf(os.Stderr, "Bench: %s for %s (%s)\n", report.Mode, opts.Duration, benchSetup(opts))
+
+	var mu sync.Mutex
+	var samples []benchSample
+	record := func(sample benchSample) {
+		if ctx.Err() != nil {
+			// Interrupted requests say nothing about the service.
+			return
+		}
+		mu.Lock()
+		samples = append(samples, sample)
+		mu.Unlock()
+	}
+
+	report.StartedAt = time.Now()
+	deadline := report.StartedAt.Add(opts.Duration)
+	var wg sync.WaitGroup
+	if opts.Rate == 0 {
+		for range opts.Concurrency {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for ctx.Err() == nil && time.Now().Before(deadline) {
+					record(sendBenchRequest(ctx, client, model, payload))
+				}
+			}()
+		}
+	} else {
+		inFlight := make(chan struct{}, opts.Concurrency)
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+		timer := time.NewTimer(opts.Duration)
+	loop:
+		for {
+			select {
+			case inFlight <- struct{}{}:
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer func() { <-inFlight }()
+					record(sendBenchRequest(ctx, client, model, payload))
+				}()
+			default:
+				report.Dropped++
+			}
+			select {
+			case <-ticker.C:
+			case <-timer.C:
+				break loop
+			case <-ctx.Done():
+				break loop
+			}
+		}
+		ticker.Stop()
+		timer.Stop()
+	}
+	wg.Wait()
+	elapsed := time.Since(report.StartedAt)
+
+	report.DurationSeconds = elapsed.Seconds()
+	report.Errors = map[string]int{}
+	var latencies, ttfbs []time.Duration
+	var tokens int
+	for _, sample := range samples {
+		if sample.errKind != "" {
+			report.Errors[sample.errKind]++
+			continue
+		}
+		latencies = append(latencies, sample.latency)
+		ttfbs = append(ttfbs, sample.ttfb)
+		tokens += sample.tokens
+	}
+	report.Requests = len(samples)
+	report.Succeeded = len(latencies)
+	report.Failed = report.Requests - report.Succeeded
+	report.ThroughputRPS = float64(report.Succeeded) / elapsed.Seconds()
+	report.TokensPerSecond = float64(tokens) / elapsed.Seconds()
+	report.Latency = summarizeLatencies(latencies)
+	report.TTFB = summarizeLatencies(ttfbs)
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "Bench interrupted; reporting the requests completed so far")
+	}
+	return report, nil
+}
+
+func benchSetup(opts benchOptions) string {
+	if opts.Rate > 0 {
+		return fmt.Sprintf("%g req/s, at most %d in flight", opts.Rate, opts.Concurrency)
+	}
+	return fmt.Sprintf("%d concurrent", opts.Concurrency)
+}
+
+func sendBenchRequest(ctx context.Context, client *refreshingClient, model string, payload []byte) benchSample {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://confsec.invalid/api/generate", bytes.NewReader(payload))
+	if err != nil {
+		return benchSample{errKind: "request"}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Confsec-Node-Tags", "model="+model)
+
+	started := time.Now()
+	resp, err := client.RoundTrip(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return benchSample{latency: time.Since(started), errKind: "timeout"}
+		}
+		return benchSample{latency: time.Since(started), errKind: "transport"}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return benchSample{latency: time.Since(started), errKind: fmt.Sprintf("http_%d", resp.StatusCode)}
+	}
+
+	body := &firstByteReader{r: resp.Body}
+	final, err := streamGenerate(body, io.Discard)
+	sample := benchSample{latency: time.Since(started), ttfb: body.first.Sub(started), tokens: final.EvalCount}
+	if err != nil {
+		sample.errKind = "stream"
+	}
+	return sample
+}
+
+// summarizeLatencies uses the nearest-rank percentile.
+func summarizeLatencies(values []time.Duration) benchLatency {
+	if len(values) == 0 {
+		return benchLatency{}
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	percentile := func(p float64) float64 {
+		rank := int(float64(len(sorted))*p+0.999999) - 1
+		return ms(sorted[max(0, min(rank, len(sorted)-1))])
+	}
+	var total time.Duration
+	for _, value := range sorted {
+		total += value
+	}
+	return benchLatency{
+		Min:  ms(sorted[0]),
+		Mean: ms(total / time.Duration(len(sorted))),
+		P50:  percentile(0.50),
+		P90:  percentile(0.90),
+		P99:  percentile(0.99),
+		Max:  ms(sorted[len(sorted)-1]),
+	}
+}
+
+func writeBenchTable(w io.Writer, report benchReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	path := "router (oHTTP disabled)"
+	if report.OHTTP {
+		path = "relay -> gateway -> router (oHTTP enabled)"
+	}
+	fmt.Fprintf(tw, "Path\t%s\n", path)
+	fmt.Fprintf(tw, "Attestation\t%s\n", report.Attestation)
+	fmt.Fprintf(tw, "Model\t%s\n", report.Model)
+	fmt.Fprintf(tw, "Duration\t%.1fs\n", report.DurationSeconds)
+	fmt.Fprintf(tw, "Requests\t%d (%d ok, %d failed)\n", report.Requests, report.Succeeded, report.Failed)
+	if report.Dropped > 0 {
+		fmt.Fprintf(tw, "Dropped\t%d (concurrency limit reached)\n", report.Dropped)
+	}
+	fmt.Fprintf(tw, "Throughput\t%.2f req/s, %.1f tokens/s\n", report.ThroughputRPS, report.TokensPerSecond)
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "\tmin\tmean\tp50\tp90\tp99\tmax")
+	for _, row := range []struct {
+		name string
+		l    benchLatency
+	}{{"Latency (ms)", report.Latency}, {"TTFB (ms)", report.TTFB}} {
+		fmt.Fprintf(tw, "%s\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\n", row.name, row.l.Min, row.l.Mean, row.l.P50, row.l.P90, row.l.P99, row.l.Max)
+	}
+	if len(report.Errors) > 0 {
+		var parts []string
+		for _, kind := range slices.Sorted(maps.Keys(report.Errors)) {
+			parts = append(parts, fmt.Sprintf("%s=%d", kind, report.Errors[kind]))
+		}
+		fmt.Fprintln(tw)
+		fmt.Fprintf(tw, "Errors\t%s\n", strings.Join(parts, " "))
+	}
+	return tw.Flush()
+}
+
+// writeBenchReport prints the table and, with -json, writes the report as
+// JSON to a file or, for "-", to stdout in place of the table.
+func writeBenchReport(report benchReport, jsonPath string) error {
+	if jsonPath != "-" {
+		if err := writeBenchTable(os.Stdout, report); err != nil {
+			return err
+		}
+	}
+	if jsonPath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if jsonPath == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(jsonPath, data, 0o644)
+}
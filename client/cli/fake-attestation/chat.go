@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const chatHelp = `Commands:
+  /reset          clear the conversation (the system prompt is kept)
+  /model [name]   show the model, or switch to another one
+  /system [text]  set the system prompt, or clear it when text is empty
+  /save <path>    write the conversation as an /api/chat request body
+  /exit           leave the chat (Ctrl-D works too)`
+
+// chatSession is the state of the chat REPL. One openpcc client is kept open
+// for the whole session, so attestation is only set up once.
+type chatSession struct {
+	client      *refreshingClient
+	badges      *badgeStore
+	attestation string
+	model       string
+	system      string
+	history     []chatMessage
+	verified    bool
+}
+
+// runChat reads prompts and commands from in until EOF or /exit.
+func runChat(client *refreshingClient, badges *badgeStore, model, attestation string, in io.Reader, out io.Writer) error {
+	session := &chatSession{client: client, badges: badges, attestation: attestation, model: model}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxStreamLineBytes)
+	fmt.Fprintln(os.Stderr, "Chat started; type /help for commands.")
+	for {
+		fmt.Fprintf(out, "[%s] %s> ", session.status(), session.model)
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "/") {
+			done, err := session.command(line, out)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+			}
+			if done {
+				return nil
+			}
+			continue
+		}
+		if err := session.send(line, out); err != nil {
+			fmt.Fprintf(os.Stderr, "Request failed: %v\n", err)
+		}
+	}
+}
+
+// status describes the attestation state for the prompt line. Nodes are only
+// listed after a request succeeded, since listing them earlier would start
+// node discovery from the prompt.
+func (s *chatSession) status() string {
+	if !s.verified {
+		return s.attestation + ": not verified yet"
+	}
+	nodes, err := s.client.CachedVerifiedNodes()
+	if err != nil || len(nodes) == 0 {
+		return s.attestation + ": verified"
+	}
+	return fmt.Sprintf("%s: %d verified node(s)", s.attestation, len(nodes))
+}
+
+func (s *chatSession) messages() []chatMessage {
+	messages := make([]chatMessage, 0, len(s.history)+1)
+	if s.system != "" {
+		messages = append(messages, chatMessage{Role: "system", Content: s.system})
+	}
+	return append(messages, s.history...)
+}
+
+func (s *chatSession) command(line string, out io.Writer) (bool, error) {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case "/help":
+		fmt.Fprintln(out, chatHelp)
+	case "/exit", "/quit":
+		return true, nil
+	case "/reset":
+		s.history = nil
+		fmt.Fprintln(out, "Conversation cleared.")
+	case "/model":
+		if arg == "" {
+			fmt.Fprintf(out, "Model: %s\n", s.model)
+			return false, nil
+		}
+		if err := s.badges.allow(arg); err != nil {
+			return false, fmt.Errorf("failed to switch model: %w", err)
+		}
+		s.model = arg
+		fmt.Fprintf(out, "Model set to %s.\n", s.model)
+	case "/system":
+		s.system = arg
+		if arg == "" {
+			fmt.Fprintln(out, "System prompt cleared.")
+		} else {
+			fmt.Fprintln(out, "System prompt set.")
+		}
+	case "/save":
+		if arg == "" {
+			return false, fmt.Errorf("usage: /save <path>")
+		}
+		if err := s.save(arg); err != nil {
+			return false, fmt.Errorf("failed to save conversation: %w", err)
+		}
+		fmt.Fprintf(out, "Saved %d message(s) to %s.\n", len(s.messages()), arg)
+	default:
+		return false, fmt.Errorf("unknown command %s (type /help)", name)
+	}
+	return false, nil
+}
+
+// save writes the conversation in /api/chat request form, so it can be
+// replayed as is.
+func (s *chatSession) save(path string) error {
+	data, err := json.MarshalIndent(map[string]any{
+		"model":    s.model,
+		"messages": s.messages(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// send posts the conversation plus text to /api/chat and streams the reply to
+// out. The turn is only added to the history when the reply completed.
+func (s *chatSession) send(text string, out io.Writer) error {
+	turn := chatMessage{Role: "user", Content: text}
+	payload, err := json.Marshal(map[string]any{
+		"model":    s.model,
+		"messages": append(s.messages(), turn),
+		"stream":   true,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://confsec.invalid/api/chat", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Confsec-Node-Tags", "model="+s.model)
+
+	resp, err := s.client.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	s.verified = true
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxProblemBodyBytes))
+		return fmt.Errorf("non-OK response: %s\n%s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var reply strings.Builder
+	final, err := streamGenerate(resp.Body, io.MultiWriter(out, &reply))
+	fmt.Fprintln(out)
+	if err != nil {
+		return err
+	}
+	s.history = append(s.history, turn, chatMessage{Role: "assistant", Content: reply.String()})
+	fmt.Fprintf(os.Stderr, "(%s)\n", final.stats())
+	return nil
+}
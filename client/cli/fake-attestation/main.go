@@ -1,18 +1,19 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"crypto/ed25519"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/openpcc/ohttp"
@@ -29,27 +30,54 @@ import (
 )
 
 const (
-	configPath           = "/etc/nnstreamer/hybrid.ini"
-	defaultRouterURL     = "http://localhost:3600"
-	defaultModel         = "llama3.2:1b"
-	defaultPrompt        = "Hello from OpenPCC."
-	defaultFakeSecret    = "123456"
-	envRouterURL         = "ROUTER_URL"
-	envAltRouterURL      = "OPENPCC_ROUTER_URL"
-	envRelayURL          = "RELAY_URL"
-	envAltRelayURL       = "OPENPCC_RELAY_URL"
-	envOHTTPSeedsJSON    = "OHTTP_SEEDS_JSON"
-	envAltOHTTPSeedsJSON = "OPENPCC_OHTTP_SEEDS_JSON"
-	envModelName         = "MODEL_NAME"
-	envPromptText        = "PROMPT_TEXT"
-	envFakeSecret        = "FAKE_ATTESTATION_SECRET"
-	routerURLConfigKey   = "router_url"
-	relayURLConfigKey    = "relay_url"
-	ohttpSeedsJSONKey    = "ohttp_seeds_json"
+	systemConfigPath         = "/etc/nnstreamer/hybrid.ini"
+	defaultRouterURL         = "http://localhost:3600"
+	defaultModel             = "llama3.2:1b"
+	defaultPrompt            = "Hello from OpenPCC."
+	defaultFakeSecret        = "123456"
+	envRouterURL             = "ROUTER_URL"
+	envAltRouterURL          = "OPENPCC_ROUTER_URL"
+	envRelayURL              = "RELAY_URL"
+	envAltRelayURL           = "OPENPCC_RELAY_URL"
+	envRelayStrategy         = "RELAY_STRATEGY"
+	envAltRelayStrategy      = "OPENPCC_RELAY_STRATEGY"
+	envProfile               = "HYBRID_PROFILE"
+	envConfigFile            = "HYBRID_CONFIG"
+	envConfigURL             = "CONFIG_URL"
+	envAltConfigURL          = "OPENPCC_CONFIG_URL"
+	envOHTTPKeysFile         = "OHTTP_KEYS_FILE"
+	envAltOHTTPKeysFile      = "OPENPCC_OHTTP_KEYS_FILE"
+	envOHTTPKeysB64          = "OHTTP_KEYS_B64"
+	envAltOHTTPKeysB64       = "OPENPCC_OHTTP_KEYS_B64"
+	envOHTTPKeysURL          = "OHTTP_KEYS_URL"
+	envAltOHTTPKeysURL       = "OPENPCC_OHTTP_KEYS_URL"
+	envOHTTPKeyPeriods       = "OHTTP_KEY_PERIODS"
+	envAltOHTTPKeyPeriods    = "OPENPCC_OHTTP_KEY_PERIODS"
+	envOHTTPSeedsJSON        = "OHTTP_SEEDS_JSON"
+	envAltOHTTPSeedsJSON     = "OPENPCC_OHTTP_SEEDS_JSON"
+	envOHTTPRevocation       = "OHTTP_REVOCATION_LIST"
+	envAltOHTTPRevocation    = "OPENPCC_OHTTP_REVOCATION_LIST"
+	envOHTTPRevocationKey    = "OHTTP_REVOCATION_PUBLIC_KEY"
+	envAltOHTTPRevocationKey = "OPENPCC_OHTTP_REVOCATION_PUBLIC_KEY"
+	envModelName             = "MODEL_NAME"
+	envPromptText            = "PROMPT_TEXT"
+	envFakeSecret            = "FAKE_ATTESTATION_SECRET"
+	routerURLConfigKey       = "router_url"
+	relayURLConfigKey        = "relay_url"
+	relayURLsConfigKey       = "relay_urls"
+	relayStrategyConfigKey   = "relay_strategy"
+	configURLConfigKey       = "config_url"
+	ohttpKeysFileKey         = "ohttp_keys_file"
+	ohttpKeysB64Key          = "ohttp_keys_b64"
+	ohttpKeysURLKey          = "ohttp_keys_url"
+	ohttpKeyPeriodsKey       = "ohttp_key_periods"
+	ohttpSeedsJSONKey        = "ohttp_seeds_json"
+	ohttpRevocationKey       = "ohttp_revocation_list"
+	ohttpRevocationPubKey    = "ohttp_revocation_public_key"
 )
 
 type fakeAuthClient struct {
-	badge        credentialing.Badge
+	badges       *badgeStore
 	remoteConfig authclient.RemoteConfig
 }
 
@@ -82,7 +110,7 @@ func (f fakeAuthClient) PutCredit(ctx context.Context, _ *anonpay.BlindedCredit)
 }
 
 func (f fakeAuthClient) GetBadge(ctx context.Context) (credentialing.Badge, error) {
-	return f.badge, nil
+	return f.badges.get(), nil
 }
 
 func (f fakeAuthClient) Payee() *anonpay.Payee {
@@ -119,12 +147,12 @@ func (w *fixedWallet) Status() wallet.Status                { return wallet.Stat
 func (w *fixedWallet) SetDefaultCreditAmount(_ int64) error { return nil }
 func (w *fixedWallet) Close(_ context.Context) error        { return nil }
 
-func makeBadge(model string) (credentialing.Badge, error) {
+func makeBadge(models ...string) (credentialing.Badge, error) {
 	badgeKey, err := inttest.NewTestBadgeKeyProvider().PrivateKey()
 	if err != nil {
 		return credentialing.Badge{}, err
 	}
-	creds := credentialing.Credentials{Models: []string{model}}
+	creds := credentialing.Credentials{Models: models}
 	credBytes, err := creds.MarshalBinary()
 	if err != nil {
 		return credentialing.Badge{}, err
@@ -133,11 +161,70 @@ func makeBadge(model string) (credentialing.Badge, error) {
 	return credentialing.Badge{Credentials: creds, Signature: sig}, nil
 }
 
+// badgeStore holds the badge the auth client hands out, so the chat REPL can
+// switch models without rebuilding the client.
+type badgeStore struct {
+	mu    sync.Mutex
+	badge credentialing.Badge
+}
+
+func newBadgeStore(model string) (*badgeStore, error) {
+	badge, err := makeBadge(model)
+	if err != nil {
+		return nil, err
+	}
+	return &badgeStore{badge: badge}, nil
+}
+
+func (s *badgeStore) get() credentialing.Badge {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.badge
+}
+
+// allow re-signs the badge so that it also covers model.
+func (s *badgeStore) allow(model string) error {
+	_, err := s.add(model)
+	return err
+}
+
+// add is allow, and also reports whether model was not covered before.
+func (s *badgeStore) add(model string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if slices.Contains(s.badge.Credentials.Models, model) {
+		return false, nil
+	}
+	badge, err := makeBadge(append(slices.Clone(s.badge.Credentials.Models), model)...)
+	if err != nil {
+		return false, err
+	}
+	s.badge = badge
+	return true, nil
+}
+
+// remove re-signs the badge without model.
+func (s *badgeStore) remove(model string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := slices.Index(s.badge.Credentials.Models, model)
+	if idx < 0 {
+		return nil
+	}
+	badge, err := makeBadge(slices.Delete(slices.Clone(s.badge.Credentials.Models), idx, idx+1)...)
+	if err != nil {
+		return err
+	}
+	s.badge = badge
+	return nil
+}
+
 type ohttpSeedSpec struct {
 	KeyID       string `json:"key_id"`
 	SeedHex     string `json:"seed_hex"`
 	ActiveFrom  string `json:"active_from"`
 	ActiveUntil string `json:"active_until"`
+	Suite       string `json:"suite"`
 }
 
 type ohttpSeedsEnvelope struct {
@@ -145,6 +232,20 @@ type ohttpSeedsEnvelope struct {
 	OHTTPSeeds []ohttpSeedSpec `json:"ohttp_seeds"`
 }
 
+// findSubcommand returns the subcommand named by the first argument, or ""
+// for the default single-prompt run.
+func findSubcommand(args []string) (string, error) {
+	if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+		return "", nil
+	}
+	switch args[1] {
+	case "chat", "batch", "bench", "serve", "models":
+		return args[1], nil
+	default:
+		return "", fmt.Errorf("unknown subcommand %q (available: chat, batch, bench, serve, models)", args[1])
+	}
+}
+
 func parseOHTTPFlag(args []string) (bool, error) {
 	raw, found, err := findOHTTPFlag(args)
 	if err != nil {
@@ -187,6 +288,47 @@ func parseOHTTPValue(raw string) (bool, error) {
 	}
 }
 
+// findStringFlag returns the value of -name/--name, in either the "=value"
+// or the separate-argument form.
+func findStringFlag(args []string, name string) (string, bool, error) {
+	for idx := 1; idx < len(args); idx++ {
+		arg := strings.TrimSpace(args[idx])
+		for _, prefix := range []string{"-" + name, "--" + name} {
+			if strings.HasPrefix(arg, prefix+"=") {
+				return strings.TrimPrefix(arg, prefix+"="), true, nil
+			}
+			if arg == prefix {
+				if idx+1 >= len(args) {
+					return "", true, fmt.Errorf("missing value for -%s", name)
+				}
+				return args[idx+1], true, nil
+			}
+		}
+	}
+	return "", false, nil
+}
+
+// findBoolFlag reports whether -name/--name is set. A bare flag means true;
+// "=value" accepts the same values as -ohttp.
+func findBoolFlag(args []string, name string) (bool, error) {
+	for idx := 1; idx < len(args); idx++ {
+		arg := strings.TrimSpace(args[idx])
+		for _, prefix := range []string{"-" + name, "--" + name} {
+			if arg == prefix {
+				return true, nil
+			}
+			if strings.HasPrefix(arg, prefix+"=") {
+				value, err := parseOHTTPValue(strings.TrimPrefix(arg, prefix+"="))
+				if err != nil {
+					return false, fmt.Errorf("invalid -%s value %q (use 1/0, t/f or true/false)", name, strings.TrimPrefix(arg, prefix+"="))
+				}
+				return value, nil
+			}
+		}
+	}
+	return false, nil
+}
+
 func parseOHTTPSeedsJSON(raw string) ([]ohttpSeedSpec, error) {
 	raw = strings.TrimSpace(raw)
 	if raw == "" {
@@ -216,7 +358,6 @@ func buildOHTTPKeyMaterial(seeds []ohttpSeedSpec) (ohttp.KeyConfigs, []gateway.K
 	if len(seeds) == 0 {
 		return nil, nil, fmt.Errorf("no ohttp seeds provided")
 	}
-	kemID, kdfID, aeadID := gateway.Suite.Params()
 	keyConfigs := make(ohttp.KeyConfigs, 0, len(seeds))
 	rotationPeriods := make([]gateway.KeyRotationPeriodWithID, 0, len(seeds))
 
@@ -234,6 +375,12 @@ func buildOHTTPKeyMaterial(seeds []ohttpSeedSpec) (ohttp.KeyConfigs, []gateway.K
 			return nil, nil, fmt.Errorf("ohttp_seeds[%d].active_until is required", idx)
 		}
 
+		suite, ok := lookupOHTTPSuite(seed.Suite)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Skipping ohttp_seeds[%d]: unsupported suite %q\n", idx, seed.Suite)
+			continue
+		}
+
 		keyID, err := parseKeyID(seed.KeyID)
 		if err != nil {
 			return nil, nil, fmt.Errorf("ohttp_seeds[%d].key_id invalid: %w", idx, err)
@@ -251,15 +398,15 @@ func buildOHTTPKeyMaterial(seeds []ohttpSeedSpec) (ohttp.KeyConfigs, []gateway.K
 			return nil, nil, fmt.Errorf("ohttp_seeds[%d].active_until invalid: %w", idx, err)
 		}
 
-		pubKey, _ := kemID.Scheme().DeriveKeyPair(seedBytes)
+		pubKey, _ := suite.KEM.Scheme().DeriveKeyPair(seedBytes)
 		keyConfigs = append(keyConfigs, ohttp.KeyConfig{
 			KeyID:     keyID,
-			KemID:     kemID,
+			KemID:     suite.KEM,
 			PublicKey: pubKey,
 			SymmetricAlgorithms: []ohttp.SymmetricAlgorithm{
 				{
-					KDFID:  kdfID,
-					AEADID: aeadID,
+					KDFID:  suite.KDF,
+					AEADID: suite.AEAD,
 				},
 			},
 		})
@@ -271,6 +418,9 @@ func buildOHTTPKeyMaterial(seeds []ohttpSeedSpec) (ohttp.KeyConfigs, []gateway.K
 			KeyID: keyID,
 		})
 	}
+	if len(keyConfigs) == 0 {
+		return nil, nil, fmt.Errorf("no ohttp seeds use a supported suite")
+	}
 
 	return keyConfigs, rotationPeriods, nil
 }
@@ -301,149 +451,300 @@ func hasHexAlpha(value string) bool {
 	return false
 }
 
-func resolveRouterURL() (string, string, error) {
+func resolveRouterURL(config hybridConfig) (string, string) {
 	if value := strings.TrimSpace(os.Getenv(envAltRouterURL)); value != "" {
-		return normalizeURL(value), "env", nil
+		return normalizeURL(value), "env"
 	}
 	if value := strings.TrimSpace(os.Getenv(envRouterURL)); value != "" {
-		return normalizeURL(value), "env", nil
+		return normalizeURL(value), "env"
 	}
 
-	value, err := routerURLFromConfig(configPath)
-	if err != nil {
-		return "", "", err
+	if value, source := config.lookup(routerURLConfigKey, "server1_url", "server_1_url"); value != "" {
+		return normalizeURL(value), source
+	}
+
+	return defaultRouterURL, "default"
+}
+
+// resolveRelayURLs returns the relays from the environment or the config
+// file. Either can list several, separated by commas.
+func resolveRelayURLs(config hybridConfig) ([]string, string, error) {
+	if value := firstEnv(envAltRelayURL, envRelayURL); value != "" {
+		return splitRelayURLs(value), "env", nil
 	}
-	if value != "" {
-		return normalizeURL(value), "config", nil
+	value, source := config.lookup(relayURLConfigKey, relayURLsConfigKey)
+	if urls := splitRelayURLs(value); len(urls) > 0 {
+		return urls, source, nil
 	}
+	return nil, "", fmt.Errorf(
+		"missing relay URL (set %s/%s or %s in %s)",
+		envRelayURL,
+		envAltRelayURL,
+		relayURLConfigKey,
+		config.paths(),
+	)
+}
 
-	return defaultRouterURL, "default", nil
+func resolveRelayStrategy(config hybridConfig) (string, string, error) {
+	if value := firstEnv(envAltRelayStrategy, envRelayStrategy); value != "" {
+		strategy, err := parseRelayStrategy(value)
+		return strategy, "env", err
+	}
+	if value, source := config.lookup(relayStrategyConfigKey); value != "" {
+		strategy, err := parseRelayStrategy(value)
+		return strategy, source, err
+	}
+	return relayStrategyRandom, "default", nil
 }
 
-func resolveRelayURL() (string, string, error) {
-	if value := strings.TrimSpace(os.Getenv(envAltRelayURL)); value != "" {
-		return normalizeURL(value), "env", nil
+// resolveConfigFiles returns the config files in increasing precedence: the
+// system file, the user's file under the XDG config directory, and the file
+// named by -config or HYBRID_CONFIG.
+func resolveConfigFiles(args []string) ([]configFile, error) {
+	files := []configFile{{Path: systemConfigPath}}
+	if dir, err := os.UserConfigDir(); err == nil {
+		files = append(files, configFile{Path: filepath.Join(dir, "nnstreamer", "hybrid.ini")})
 	}
-	if value := strings.TrimSpace(os.Getenv(envRelayURL)); value != "" {
-		return normalizeURL(value), "env", nil
+	value, found, err := findStringFlag(args, "config")
+	if err != nil {
+		return nil, err
 	}
+	if found {
+		if strings.TrimSpace(value) == "" {
+			return nil, fmt.Errorf("empty value for -config")
+		}
+	} else {
+		value = firstEnv(envConfigFile)
+	}
+	if value = strings.TrimSpace(value); value != "" {
+		files = append(files, configFile{Path: value, Required: true})
+	}
+	return files, nil
+}
 
-	value, err := relayURLFromConfig(configPath)
+// resolveProfile returns the hybrid.ini profile named by -profile or
+// HYBRID_PROFILE, or "" for the default settings.
+func resolveProfile(args []string) (string, error) {
+	value, found, err := findStringFlag(args, "profile")
+	if err != nil {
+		return "", err
+	}
+	if found {
+		if strings.TrimSpace(value) == "" {
+			return "", fmt.Errorf("empty value for -profile")
+		}
+		return strings.TrimSpace(value), nil
+	}
+	return firstEnv(envProfile), nil
+}
+
+// resolveConfigURL returns the server-3 /api/config URL, or "" when the CLI
+// should build its client config from local settings.
+func resolveConfigURL(args []string, config hybridConfig) (string, string, error) {
+	value, found, err := findStringFlag(args, "config-url")
 	if err != nil {
 		return "", "", err
 	}
-	if value != "" {
-		return normalizeURL(value), "config", nil
+	if found {
+		if strings.TrimSpace(value) == "" {
+			return "", "", fmt.Errorf("empty value for -config-url")
+		}
+		return normalizeURL(value), "flag", nil
+	}
+	if value := firstEnv(envAltConfigURL, envConfigURL); value != "" {
+		return normalizeURL(value), "env", nil
+	}
+	if value, source := config.lookup(configURLConfigKey); value != "" {
+		return normalizeURL(value), source, nil
 	}
+	return "", "", nil
+}
 
+// resolveOHTTPKeySource returns where the public key configs come from. The
+// zero value means none is configured.
+func resolveOHTTPKeySource(config hybridConfig) ohttpKeySource {
+	sources := []struct {
+		kind      string
+		envs      []string
+		configKey string
+	}{
+		{"file", []string{envAltOHTTPKeysFile, envOHTTPKeysFile}, ohttpKeysFileKey},
+		{"base64", []string{envAltOHTTPKeysB64, envOHTTPKeysB64}, ohttpKeysB64Key},
+		{"url", []string{envAltOHTTPKeysURL, envOHTTPKeysURL}, ohttpKeysURLKey},
+	}
+	for _, src := range sources {
+		if value := firstEnv(src.envs...); value != "" {
+			return ohttpKeySource{Kind: src.kind, Value: value, Source: "env"}
+		}
+	}
+	for _, src := range sources {
+		if value, source := config.lookup(src.configKey); value != "" {
+			return ohttpKeySource{Kind: src.kind, Value: value, Source: source}
+		}
+	}
+	return ohttpKeySource{}
+}
+
+func resolveOHTTPKeyPeriods(config hybridConfig) (string, string, error) {
+	if value := firstEnv(envAltOHTTPKeyPeriods, envOHTTPKeyPeriods); value != "" {
+		return value, "env", nil
+	}
+	if value, source := config.lookup(ohttpKeyPeriodsKey); value != "" {
+		return value, source, nil
+	}
 	return "", "", fmt.Errorf(
-		"missing relay URL (set %s/%s or %s in %s)",
-		envRelayURL,
-		envAltRelayURL,
-		relayURLConfigKey,
-		configPath,
+		"missing OHTTP key rotation periods (set %s/%s or %s in %s)",
+		envOHTTPKeyPeriods,
+		envAltOHTTPKeyPeriods,
+		ohttpKeyPeriodsKey,
+		config.paths(),
 	)
 }
 
-func resolveOHTTPSeedsJSON() (string, string, error) {
+func resolveOHTTPSeedsJSON(config hybridConfig) (string, string, error) {
 	if value := strings.TrimSpace(os.Getenv(envAltOHTTPSeedsJSON)); value != "" {
 		return value, "env", nil
 	}
 	if value := strings.TrimSpace(os.Getenv(envOHTTPSeedsJSON)); value != "" {
 		return value, "env", nil
 	}
-
-	value, err := ohttpSeedsJSONFromConfig(configPath)
-	if err != nil {
-		return "", "", err
-	}
-	if value != "" {
-		return value, "config", nil
+	if value, source := config.lookup(ohttpSeedsJSONKey); value != "" {
+		return value, source, nil
 	}
-
 	return "", "", fmt.Errorf(
 		"missing OHTTP seeds JSON (set %s/%s or %s in %s)",
 		envOHTTPSeedsJSON,
 		envAltOHTTPSeedsJSON,
 		ohttpSeedsJSONKey,
-		configPath,
+		config.paths(),
 	)
 }
 
-func routerURLFromConfig(path string) (string, error) {
-	return configValueFromFile(path, routerURLConfigKey, "server1_url", "server_1_url")
-}
-
-func relayURLFromConfig(path string) (string, error) {
-	return configValueFromFile(path, relayURLConfigKey)
-}
-
-func ohttpSeedsJSONFromConfig(path string) (string, error) {
-	return configValueFromFile(path, ohttpSeedsJSONKey)
+// resolveOHTTPRevocation returns the signed revocation list (a path or inline
+// JSON) and its public key. Both are empty when no list is configured.
+func resolveOHTTPRevocation(config hybridConfig) (string, string, string, error) {
+	value, source := firstEnv(envAltOHTTPRevocation, envOHTTPRevocation), "env"
+	if value == "" {
+		value, source = config.lookup(ohttpRevocationKey)
+	}
+	if value == "" {
+		return "", "", "", nil
+	}
+	publicKey := firstNonEmpty(
+		firstEnv(envAltOHTTPRevocationKey, envOHTTPRevocationKey),
+		config.value(ohttpRevocationPubKey),
+	)
+	if publicKey == "" {
+		return "", "", "", fmt.Errorf(
+			"revocation list set but no public key (set %s/%s or %s in %s)",
+			envOHTTPRevocationKey,
+			envAltOHTTPRevocationKey,
+			ohttpRevocationPubKey,
+			config.paths(),
+		)
+	}
+	return value, publicKey, source, nil
 }
 
-func configValueFromFile(path string, keys ...string) (string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return "", nil
-		}
-		return "", err
+// loadOHTTPKeys loads the public key configs and their rotation periods. With
+// a server-3 config its rotation periods apply. Without public key configs it
+// falls back to the dev-only seeds.
+func loadOHTTPKeys(config hybridConfig, remote *remoteClientConfig) (ohttp.KeyConfigs, []gateway.KeyRotationPeriodWithID, error) {
+	keySource := resolveOHTTPKeySource(config)
+	if keySource.Kind == "" {
+		return loadDevOHTTPSeeds(config, remote)
 	}
-	defer file.Close()
 
-	keySet := map[string]struct{}{}
-	for _, key := range keys {
-		keySet[strings.ToLower(key)] = struct{}{}
+	client := newProxyHTTPClient()
+	keyConfigs, err := loadOHTTPKeyConfigs(context.Background(), client, keySource)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load OHTTP key configs (%s): %w", keySource, err)
 	}
+	fmt.Fprintf(os.Stderr, "OHTTP enabled: using %d public key config(s) (%s)\n", len(keyConfigs), keySource)
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
-			continue
-		}
-		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
-			continue
-		}
-		key, value, ok := splitKeyValue(line)
-		if !ok {
-			continue
-		}
-		key = strings.ToLower(key)
-		if _, ok := keySet[key]; !ok {
-			continue
+	var periods map[byte]ohttpKeyPeriodSpec
+	if remote != nil {
+		periods = remote.Periods
+		fmt.Fprintf(os.Stderr, "OHTTP enabled: using key rotation periods (server-3)\n")
+	} else {
+		value, source, err := resolveOHTTPKeyPeriods(config)
+		if err != nil {
+			return nil, nil, err
 		}
-		value = stripInlineComment(value)
-		value = strings.TrimSpace(strings.Trim(value, `"'`))
-		if value != "" {
-			return value, nil
+		periods, err = loadOHTTPKeyPeriods(context.Background(), client, value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load OHTTP key rotation periods (%s): %w", source, err)
 		}
+		fmt.Fprintf(os.Stderr, "OHTTP enabled: using key rotation periods (%s)\n", source)
 	}
-	if err := scanner.Err(); err != nil {
-		return "", err
-	}
-	return "", nil
+	return publicOHTTPKeyMaterial(keyConfigs, periods)
 }
 
-func splitKeyValue(line string) (string, string, bool) {
-	parts := strings.SplitN(line, "=", 2)
-	if len(parts) != 2 {
-		return "", "", false
+// loadDevOHTTPSeeds derives key configs from seeds. The seeds are the
+// gateway's private keys, so this is for development setups only.
+func loadDevOHTTPSeeds(config hybridConfig, remote *remoteClientConfig) (ohttp.KeyConfigs, []gateway.KeyRotationPeriodWithID, error) {
+	seedsJSON, seedsSource, err := resolveOHTTPSeedsJSON(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf(
+			"missing OHTTP key configs (set %s, %s or %s, or %s, %s or %s in %s): %w",
+			envOHTTPKeysFile, envOHTTPKeysB64, envOHTTPKeysURL,
+			ohttpKeysFileKey, ohttpKeysB64Key, ohttpKeysURLKey, config.paths(),
+			err,
+		)
 	}
-	key := strings.TrimSpace(parts[0])
-	value := strings.TrimSpace(parts[1])
-	if key == "" || value == "" {
-		return "", "", false
+	fmt.Fprintf(os.Stderr, "Warning: deriving OHTTP keys from private seeds (%s); this is for development only\n", seedsSource)
+	seeds, err := parseOHTTPSeedsJSON(seedsJSON)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse OHTTP seeds JSON: %w", err)
+	}
+	var keyConfigs ohttp.KeyConfigs
+	var rotationPeriods []gateway.KeyRotationPeriodWithID
+	if remote != nil {
+		keyConfigs, rotationPeriods, err = remote.seedKeyMaterial(seeds)
+	} else {
+		keyConfigs, rotationPeriods, err = buildOHTTPKeyMaterial(seeds)
 	}
-	return key, value, true
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build OHTTP key configs: %w", err)
+	}
+	return keyConfigs, rotationPeriods, nil
 }
 
-func stripInlineComment(value string) string {
-	if idx := strings.IndexAny(value, "#;"); idx >= 0 {
-		return strings.TrimSpace(value[:idx])
+// loadOHTTPKeyMaterial loads the keys and the revocation list from config and
+// the environment and selects the key to use. Keys the gateway rejected
+// earlier in this run (by fingerprint) are skipped.
+func loadOHTTPKeyMaterial(config hybridConfig, remote *remoteClientConfig, rejected []string) (ohttp.KeyConfigs, []gateway.KeyRotationPeriodWithID, error) {
+	keyConfigs, rotationPeriods, err := loadOHTTPKeys(config, remote)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	revocationValue, revocationKey, revocationSource, err := resolveOHTTPRevocation(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve OHTTP revocation list: %w", err)
+	}
+	if revocationValue != "" {
+		revocations, err := loadOHTTPRevocationList(revocationValue, revocationKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load OHTTP revocation list: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "OHTTP enabled: using revocation list (%s) issued at %s\n", revocationSource, revocations.IssuedAt.Format(time.RFC3339))
+		keyConfigs, rotationPeriods, err = dropRevokedOHTTPKeys(revocations, keyConfigs, rotationPeriods)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	keyConfigs, rotationPeriods, err = dropRejectedOHTTPKeys(rejected, keyConfigs, rotationPeriods)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyConfigs, rotationPeriods, err = selectStrongestOHTTPKey(keyConfigs, rotationPeriods, time.Now())
+	if err != nil {
+		return nil, nil, err
 	}
-	return value
+	fmt.Fprintf(os.Stderr, "OHTTP enabled: using key_id %d (KEM 0x%04x)\n", keyConfigs[0].KeyID, uint16(keyConfigs[0].KemID))
+	return keyConfigs, rotationPeriods, nil
 }
 
 func normalizeURL(raw string) string {
@@ -457,6 +758,15 @@ func normalizeURL(raw string) string {
 	return "http://" + raw
 }
 
+func firstEnv(keys ...string) string {
+	for _, key := range keys {
+		if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
 func firstNonEmpty(values ...string) string {
 	for _, value := range values {
 		if strings.TrimSpace(value) != "" {
@@ -467,47 +777,138 @@ func firstNonEmpty(values ...string) string {
 }
 
 func main() {
+	subcommand, err := findSubcommand(os.Args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
 	ohttpEnabled, err := parseOHTTPFlag(os.Args)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(2)
 	}
+	stream, err := findBoolFlag(os.Args, "stream")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+	// batch uses -output for its results file, parsed by parseBatchOptions.
+	var output string
+	if subcommand != "batch" {
+		output, err = parseOutputFormat(os.Args, stream)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(2)
+		}
+	}
+	checkModel, err := findBoolFlag(os.Args, "check-model")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+	var batch batchOptions
+	var bench benchOptions
+	var serve serveOptions
+	switch subcommand {
+	case "batch":
+		batch, err = parseBatchOptions(os.Args)
+	case "bench":
+		bench, err = parseBenchOptions(os.Args)
+	case "serve":
+		serve, err = parseServeOptions(os.Args)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+
+	profile, err := resolveProfile(os.Args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+	configFiles, err := resolveConfigFiles(os.Args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+	config, err := loadConfig(configFiles, profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read config: %v\n", err)
+		os.Exit(1)
+	}
+	for _, section := range config.ignored {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring config section %s; only [default] and [profile NAME] are read\n", section)
+	}
+	if profile != "" {
+		fmt.Fprintf(os.Stderr, "Using config profile %s\n", profile)
+	}
 
 	model := firstNonEmpty(os.Getenv(envModelName), defaultModel)
 	prompt := firstNonEmpty(os.Getenv(envPromptText), defaultPrompt)
 	fakeSecret := firstNonEmpty(os.Getenv(envFakeSecret), defaultFakeSecret)
 
-	badge, err := makeBadge(model)
+	badges, err := newBadgeStore(model)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to create badge: %v\n", err)
 		os.Exit(1)
 	}
 
+	configURL, configURLSource, err := resolveConfigURL(os.Args, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to resolve config URL: %v\n", err)
+		os.Exit(2)
+	}
+	var remote *remoteClientConfig
+	if configURL != "" {
+		remote, err = fetchRemoteConfig(context.Background(), newProxyHTTPClient(), configURL)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load client config from %s: %v\n", configURL, err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Using client config (%s): %s (version %s, ohttp=%t, real_attestation=%t)\n",
+			configURLSource, configURL, remoteConfigVersion, remote.OHTTP, remote.RealAttestation)
+		if ohttpEnabled && !remote.OHTTP {
+			fmt.Fprintf(os.Stderr, "Client config from %s has oHTTP disabled; run with -ohttp=disable\n", configURL)
+			os.Exit(1)
+		}
+		if remote.RealAttestation {
+			fmt.Fprintf(os.Stderr, "Note: client config requires real attestation (policy %s); this CLI only performs fake attestation\n", remote.Attestation.PolicyID)
+		}
+	}
+
 	var routerURL string
 	var routerSource string
-	var relayURL string
+	var relayURLs []string
 	var relaySource string
-	var seedsJSON string
-	var seedsSource string
+	var relayStrategy, relayStrategySource string
 
 	if ohttpEnabled {
-		relayURL, relaySource, err = resolveRelayURL()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to resolve relay URL: %v\n", err)
-			os.Exit(1)
+		if remote != nil {
+			relayURLs, relaySource = remote.RelayURLs, "server-3"
+		} else {
+			relayURLs, relaySource, err = resolveRelayURLs(config)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to resolve relay URL: %v\n", err)
+				os.Exit(1)
+			}
 		}
-		seedsJSON, seedsSource, err = resolveOHTTPSeedsJSON()
+		relayStrategy, relayStrategySource, err = resolveRelayStrategy(config)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to resolve OHTTP seeds JSON: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Failed to resolve relay strategy: %v\n", err)
+			os.Exit(2)
+		}
+		if len(relayURLs) > 1 {
+			fmt.Fprintf(os.Stderr, "OHTTP enabled: using %d relays (%s), picked by %s (%s): %s\n",
+				len(relayURLs), relaySource, relayStrategy, relayStrategySource, strings.Join(relayURLs, ", "))
+		} else {
+			fmt.Fprintf(os.Stderr, "OHTTP enabled: using relay URL (%s): %s\n", relaySource, relayURLs[0])
 		}
-		fmt.Fprintf(os.Stderr, "OHTTP enabled: using relay URL (%s): %s\n", relaySource, relayURL)
-		fmt.Fprintf(os.Stderr, "OHTTP enabled: using seeds JSON (%s)\n", seedsSource)
 	} else {
-		routerURL, routerSource, err = resolveRouterURL()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to resolve router URL: %v\n", err)
-			os.Exit(1)
+		if remote != nil && remote.RouterURL != "" {
+			routerURL, routerSource = normalizeURL(remote.RouterURL), "server-3"
+		} else {
+			routerURL, routerSource = resolveRouterURL(config)
 		}
 		fmt.Fprintf(os.Stderr, "OHTTP disabled: using router URL (%s): %s\n", routerSource, routerURL)
 	}
@@ -525,54 +926,143 @@ func main() {
 	cfg.TransparencyIdentityPolicySource = openpcc.IdentityPolicySourceConfigured
 
 	nonAnonClient := newProxyHTTPClient()
+	if ohttpEnabled {
+		if _, err := withRelayPool(nonAnonClient, relayURLs, relayStrategy); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to set up relays: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	withKeyProblemDetector(nonAnonClient)
 	options := []openpcc.Option{
 		openpcc.WithWallet(&fixedWallet{}),
 		openpcc.WithNonAnonHTTPClient(nonAnonClient),
 		openpcc.WithFakeAttestationSecret(fakeSecret),
 	}
+	if !ohttpEnabled {
+		anonClient := newProxyHTTPClient()
+		options = append(options, openpcc.WithRouterURL(routerURL), openpcc.WithAnonHTTPClient(anonClient))
+	}
+
+	buildClient := func(keyConfigs ohttp.KeyConfigs, rotationPeriods []gateway.KeyRotationPeriodWithID) (*openpcc.Client, error) {
+		remoteConfig := authclient.RemoteConfig{}
+		if ohttpEnabled {
+			remoteConfig = authclient.RemoteConfig{
+				OHTTPRelayURLs:          relayURLs,
+				OHTTPKeyConfigs:         keyConfigs,
+				OHTTPKeyRotationPeriods: rotationPeriods,
+			}
+		}
+		clientOptions := append(slices.Clone(options), openpcc.WithAuthClient(fakeAuthClient{
+			badges:       badges,
+			remoteConfig: remoteConfig,
+		}))
+		return openpcc.NewFromConfig(context.Background(), cfg, clientOptions...)
+	}
 
-	remoteConfig := authclient.RemoteConfig{}
+	// Refreshing re-reads the config file and fetches the server-3 config
+	// again, so key material updated there since startup is picked up.
+	reloadKeyMaterial := func(rejected []string) (ohttp.KeyConfigs, []gateway.KeyRotationPeriodWithID, error) {
+		config, err := loadConfig(configFiles, profile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read config: %w", err)
+		}
+		if configURL == "" {
+			return loadOHTTPKeyMaterial(config, nil, rejected)
+		}
+		latest, err := fetchRemoteConfig(context.Background(), newProxyHTTPClient(), configURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load client config from %s: %w", configURL, err)
+		}
+		return loadOHTTPKeyMaterial(config, latest, rejected)
+	}
+
+	var keyConfigs ohttp.KeyConfigs
+	var rotationPeriods []gateway.KeyRotationPeriodWithID
 	if ohttpEnabled {
-		seeds, err := parseOHTTPSeedsJSON(seedsJSON)
+		keyConfigs, rotationPeriods, err = loadOHTTPKeyMaterial(config, remote, nil)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to parse OHTTP seeds JSON: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Failed to load OHTTP key material: %v\n", err)
 			os.Exit(1)
 		}
-		keyConfigs, rotationPeriods, err := buildOHTTPKeyMaterial(seeds)
+	}
+
+	// Discovery does not need the openpcc client, so a missing model is
+	// reported before the client is set up.
+	discovery := routerDiscovery{OHTTP: ohttpEnabled, RouterURL: routerURL, HTTPClient: nonAnonClient}
+	if ohttpEnabled {
+		discovery.RelayURL, discovery.KeyConfig = relayURLs[0], keyConfigs[0]
+	}
+	if subcommand == "models" || checkModel {
+		catalog, err := discoverModels(context.Background(), discovery)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to build OHTTP key configs: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Model discovery failed: %v\n", err)
 			os.Exit(1)
 		}
-		remoteConfig = authclient.RemoteConfig{
-			OHTTPRelayURLs:          []string{relayURL},
-			OHTTPKeyConfigs:         keyConfigs,
-			OHTTPKeyRotationPeriods: rotationPeriods,
+		if subcommand == "models" {
+			if err := writeModels(os.Stdout, output, catalog); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write models: %v\n", err)
+				os.Exit(1)
+			}
+			if err := catalog.checkModel(model); checkModel && err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			return
 		}
-	} else {
-		anonClient := newProxyHTTPClient()
-		options = append(options, openpcc.WithRouterURL(routerURL), openpcc.WithAnonHTTPClient(anonClient))
+		if err := catalog.checkModel(model); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Model %s is served by %d node(s)\n", model, catalog.nodeCount(model))
 	}
 
-	options = append(options, openpcc.WithAuthClient(fakeAuthClient{
-		badge:        badge,
-		remoteConfig: remoteConfig,
-	}))
-
-	client, err := openpcc.NewFromConfig(
-		context.Background(),
-		cfg,
-		options...,
-	)
+	client, err := newRefreshingClient(buildClient, reloadKeyMaterial, keyConfigs, rotationPeriods)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to initialize OpenPCC client: %v\n", err)
 		os.Exit(1)
 	}
 	defer client.Close(context.Background())
 
+	switch subcommand {
+	case "chat":
+		if err := runChat(client, badges, model, "fake attestation", os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Chat failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "batch":
+		failed, err := runBatch(client, badges, model, batch)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Batch failed: %v\n", err)
+			os.Exit(1)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	case "bench":
+		report, err := runBench(client, model, prompt, bench, benchReport{OHTTP: ohttpEnabled, Attestation: "fake attestation"})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Bench failed: %v\n", err)
+			os.Exit(1)
+		}
+		if err := writeBenchReport(report, bench.JSONPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write bench report: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "serve":
+		if err := runServe(client, badges, model, discovery, serve); err != nil {
+			fmt.Fprintf(os.Stderr, "Serve failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	payload, err := json.Marshal(map[string]interface{}{
 		"model":  model,
 		"prompt": prompt,
-		"stream": false,
+		"stream": stream,
 	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to build request body: %v\n", err)
@@ -584,9 +1074,11 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Failed to create request: %v\n", err)
 		os.Exit(1)
 	}
+	nodeTags := []string{"model=" + model}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Confsec-Node-Tags", "model="+model)
+	req.Header.Set("X-Confsec-Node-Tags", strings.Join(nodeTags, ","))
 
+	started := time.Now()
 	resp, err := client.RoundTrip(req)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Request failed: %v\n", err)
@@ -594,16 +1086,14 @@ func main() {
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to read response: %v\n", err)
-		os.Exit(1)
+	meta := responseMeta{
+		Model:       model,
+		NodeTags:    nodeTags,
+		OHTTP:       ohttpEnabled,
+		Attestation: "fake",
 	}
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		fmt.Fprintf(os.Stderr, "Non-OK response: %s\n%s\n", resp.Status, string(body))
+	if err := writeResponse(os.Stdout, resp, output, stream, meta, client, started); err != nil {
+		fmt.Fprintf(os.Stderr, "Request failed: %v\n", err)
 		os.Exit(1)
 	}
-
-	fmt.Println(string(body))
 }
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/openpcc/ohttp"
+	obhttp "github.com/openpcc/ohttp/encoding/bhttp"
+	"github.com/openpcc/openpcc/ahttp"
+	"github.com/openpcc/openpcc/gateway"
+	rtrpb "github.com/openpcc/openpcc/gen/protos/router"
+	"github.com/openpcc/openpcc/messages"
+	"github.com/openpcc/openpcc/proton"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// maxDiscoveredNodes is the limit sent to /compute-manifests; the router
+	// returns nothing for a limit of zero.
+	maxDiscoveredNodes    = 1024
+	modelDiscoveryTimeout = 30 * time.Second
+)
+
+// routerDiscovery says how to reach the router's /compute-manifests: directly
+// at RouterURL, or through the relay with the same oHTTP key the client uses.
+type routerDiscovery struct {
+	OHTTP      bool
+	RouterURL  string
+	RelayURL   string
+	KeyConfig  ohttp.KeyConfig
+	HTTPClient *http.Client
+}
+
+type modelSummary struct {
+	Model   string   `json:"model"`
+	Nodes   int      `json:"nodes"`
+	Engines []string `json:"engines"`
+	Tags    []string `json:"tags"`
+}
+
+// modelCatalog is what the router reports, grouped by the model= tag.
+// Untagged counts nodes without one.
+type modelCatalog struct {
+	Router   string         `json:"router"`
+	OHTTP    bool           `json:"ohttp"`
+	Nodes    int            `json:"nodes"`
+	Untagged int            `json:"untagged_nodes"`
+	Models   []modelSummary `json:"models"`
+}
+
+// nodeCount returns how many nodes serve model.
+func (c modelCatalog) nodeCount(model string) int {
+	for _, m := range c.Models {
+		if m.Model == model {
+			return m.Nodes
+		}
+	}
+	return 0
+}
+
+func (c modelCatalog) names() []string {
+	names := make([]string, 0, len(c.Models))
+	for _, m := range c.Models {
+		names = append(names, m.Model)
+	}
+	return names
+}
+
+// checkModel returns an error unless some node serves model.
+func (c modelCatalog) checkModel(model string) error {
+	if c.nodeCount(model) > 0 {
+		return nil
+	}
+	available := "none"
+	if len(c.Models) > 0 {
+		available = strings.Join(c.names(), ", ")
+	}
+	return fmt.Errorf("model %q is not served by any node (available: %s)", model, available)
+}
+
+func (d routerDiscovery) client() (*http.Client, string, error) {
+	if !d.OHTTP {
+		return d.HTTPClient, d.RouterURL, nil
+	}
+	// Same encoding as the openpcc client, so the gateway treats these like
+	// its other requests.
+	reqEncoder, err := obhttp.NewRequestEncoder(
+		obhttp.FixedLengthRequestChunks(),
+		obhttp.MaxRequestChunkLen(messages.EncapsulatedChunkLen()),
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create ohttp request encoder: %w", err)
+	}
+	transport, err := ohttp.NewTransport(
+		d.KeyConfig,
+		d.RelayURL,
+		ohttp.WithHTTPClient(d.HTTPClient),
+		ohttp.WithRequestEncoder(reqEncoder),
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create ohttp transport: %w", err)
+	}
+	return &http.Client{Transport: transport}, "http://" + gateway.ExternalRouterHost, nil
+}
+
+// target names where the queries go, for reports.
+func (d routerDiscovery) target() string {
+	if d.OHTTP {
+		return d.RelayURL
+	}
+	return d.RouterURL
+}
+
+// fetchComputeManifests returns the manifests of the nodes registered with
+// the router that carry all of tags. The router charges an attestation
+// token per query, like the client's own node discovery.
+func fetchComputeManifests(ctx context.Context, d routerDiscovery, tags []string) ([]*rtrpb.ComputeManifest, error) {
+	ctx, cancel := context.WithTimeout(ctx, modelDiscoveryTimeout)
+	defer cancel()
+
+	httpClient, baseURL, err := d.client()
+	if err != nil {
+		return nil, err
+	}
+	query := &rtrpb.ComputeManifestRequest{}
+	query.SetLimit(maxDiscoveredNodes)
+	query.SetTags(tags)
+	data, err := proto.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal compute manifest query: %w", err)
+	}
+	token, err := blindCredit(ctx, ahttp.AttestationCurrencyValue)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create attestation token: %w", err)
+	}
+	creditHeader, err := token.MarshalText()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal attestation token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/compute-manifests", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(ahttp.CreditHeader, string(creditHeader))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("compute manifest request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxProblemBodyBytes))
+		return nil, fmt.Errorf("router replied %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	list := &rtrpb.ComputeManifestList{}
+	if err := proton.NewDecoder(resp.Body).Decode(list); err != nil {
+		return nil, fmt.Errorf("failed to decode compute manifests: %w", err)
+	}
+	return list.GetItems(), nil
+}
+
+// discoverModels lists the models served by the nodes registered with the
+// router.
+func discoverModels(ctx context.Context, d routerDiscovery) (modelCatalog, error) {
+	catalog := modelCatalog{Router: d.target(), OHTTP: d.OHTTP, Models: []modelSummary{}}
+	items, err := fetchComputeManifests(ctx, d, nil)
+	if err != nil {
+		return catalog, err
+	}
+	summarizeManifests(&catalog, items)
+	return catalog, nil
+}
+
+func summarizeManifests(catalog *modelCatalog, items []*rtrpb.ComputeManifest) {
+	byModel := map[string]*modelSummary{}
+	for _, item := range items {
+		catalog.Nodes++
+		var models, engines, other []string
+		for _, tag := range item.GetTags() {
+			switch key, value, _ := strings.Cut(tag, "="); key {
+			case "model":
+				models = append(models, value)
+			case "engine":
+				engines = append(engines, value)
+			default:
+				other = append(other, tag)
+			}
+		}
+		if len(models) == 0 {
+			catalog.Untagged++
+			continue
+		}
+		for _, model := range models {
+			summary, ok := byModel[model]
+			if !ok {
+				summary = &modelSummary{Model: model, Engines: []string{}, Tags: []string{}}
+				byModel[model] = summary
+			}
+			summary.Nodes++
+			summary.Engines = appendMissing(summary.Engines, engines...)
+			summary.Tags = appendMissing(summary.Tags, other...)
+		}
+	}
+	for _, summary := range byModel {
+		slices.Sort(summary.Engines)
+		slices.Sort(summary.Tags)
+		catalog.Models = append(catalog.Models, *summary)
+	}
+	slices.SortFunc(catalog.Models, func(a, b modelSummary) int { return strings.Compare(a.Model, b.Model) })
+}
+
+func appendMissing(list []string, values ...string) []string {
+	for _, value := range values {
+		if !slices.Contains(list, value) {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+func writeModelTable(w io.Writer, catalog modelCatalog) error {
+	fmt.Fprintf(w, "Router: %s (ohttp=%t), %d node(s)\n", catalog.Router, catalog.OHTTP, catalog.Nodes)
+	if len(catalog.Models) == 0 {
+		fmt.Fprintln(w, "No models available.")
+	} else {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "MODEL\tNODES\tENGINES\tTAGS")
+		for _, m := range catalog.Models {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", m.Model, m.Nodes, joinOrDash(m.Engines), joinOrDash(m.Tags))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if catalog.Untagged > 0 {
+		fmt.Fprintf(w, "%d node(s) have no model tag.\n", catalog.Untagged)
+	}
+	return nil
+}
+
+func joinOrDash(values []string) string {
+	if len(values) == 0 {
+		return "-"
+	}
+	return strings.Join(values, ",")
+}
+
+// writeModels prints catalog as a table, or as JSON for -output json/jsonl.
+func writeModels(w io.Writer, format string, catalog modelCatalog) error {
+	switch format {
+	case outputJSON:
+		data, err := json.MarshalIndent(catalog, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	case outputJSONL:
+		data, err := json.Marshal(catalog)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	default:
+		return writeModelTable(w, catalog)
+	}
+}
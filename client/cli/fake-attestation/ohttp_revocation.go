@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/openpcc/ohttp"
+	"github.com/openpcc/openpcc/gateway"
+)
+
+const fingerprintPrefix = "sha256:"
+
+// ohttpRevocationEntry revokes every key config that matches all of its set
+// fields. The format is shared with mem-gateway.
+type ohttpRevocationEntry struct {
+	KeyID       string `json:"key_id"`
+	Fingerprint string `json:"fingerprint"`
+	Reason      string `json:"reason"`
+}
+
+type ohttpRevocationList struct {
+	IssuedAt time.Time              `json:"issued_at"`
+	Revoked  []ohttpRevocationEntry `json:"revoked"`
+}
+
+type signedOHTTPRevocationList struct {
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// loadOHTTPRevocationList reads a signed list from a file path, or takes the
+// value itself when it is inline JSON, and verifies it with publicKeyRaw
+// (Ed25519, hex or base64).
+func loadOHTTPRevocationList(value, publicKeyRaw string) (*ohttpRevocationList, error) {
+	raw := []byte(value)
+	if !strings.HasPrefix(strings.TrimSpace(value), "{") {
+		var err error
+		raw, err = os.ReadFile(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	publicKey, err := hex.DecodeString(publicKeyRaw)
+	if err != nil {
+		publicKey, err = base64.StdEncoding.DecodeString(publicKeyRaw)
+		if err != nil {
+			return nil, errors.New("revocation public key is not hex or base64")
+		}
+	}
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("revocation public key must be %d bytes", ed25519.PublicKeySize)
+	}
+
+	var signed signedOHTTPRevocationList
+	if err := json.Unmarshal(raw, &signed); err != nil {
+		return nil, fmt.Errorf("invalid revocation list envelope: %w", err)
+	}
+	payload, err := base64.StdEncoding.DecodeString(signed.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("revocation list payload is not base64: %w", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(signed.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("revocation list signature is not base64: %w", err)
+	}
+	if !ed25519.Verify(publicKey, payload, signature) {
+		return nil, errors.New("revocation list signature verification failed")
+	}
+
+	var list ohttpRevocationList
+	if err := json.Unmarshal(payload, &list); err != nil {
+		return nil, fmt.Errorf("invalid revocation list payload: %w", err)
+	}
+	if list.IssuedAt.IsZero() {
+		return nil, errors.New("revocation list issued_at is required")
+	}
+	for idx, entry := range list.Revoked {
+		if strings.TrimSpace(entry.KeyID) == "" && strings.TrimSpace(entry.Fingerprint) == "" {
+			return nil, fmt.Errorf("revoked[%d] needs key_id or fingerprint", idx)
+		}
+		if strings.TrimSpace(entry.KeyID) != "" {
+			if _, err := parseKeyID(entry.KeyID); err != nil {
+				return nil, fmt.Errorf("revoked[%d].key_id invalid: %w", idx, err)
+			}
+		}
+		if entry.Fingerprint != "" {
+			fingerprint := strings.ToLower(strings.TrimSpace(entry.Fingerprint))
+			digest, err := hex.DecodeString(strings.TrimPrefix(fingerprint, fingerprintPrefix))
+			if !strings.HasPrefix(fingerprint, fingerprintPrefix) || err != nil || len(digest) != sha256.Size {
+				return nil, fmt.Errorf("revoked[%d].fingerprint must be sha256:<64 hex digits>", idx)
+			}
+			list.Revoked[idx].Fingerprint = fingerprint
+		}
+	}
+	return &list, nil
+}
+
+func ohttpKeyFingerprint(kc ohttp.KeyConfig) (string, error) {
+	raw, err := kc.PublicKey.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return fingerprintPrefix + hex.EncodeToString(sum[:]), nil
+}
+
+func (l *ohttpRevocationList) revokes(kc ohttp.KeyConfig) (ohttpRevocationEntry, bool, error) {
+	fingerprint, err := ohttpKeyFingerprint(kc)
+	if err != nil {
+		return ohttpRevocationEntry{}, false, err
+	}
+	for _, entry := range l.Revoked {
+		if strings.TrimSpace(entry.KeyID) != "" {
+			keyID, _ := parseKeyID(entry.KeyID)
+			if keyID != kc.KeyID {
+				continue
+			}
+		}
+		if entry.Fingerprint != "" && entry.Fingerprint != fingerprint {
+			continue
+		}
+		return entry, true, nil
+	}
+	return ohttpRevocationEntry{}, false, nil
+}
+
+// dropRevokedOHTTPKeys removes revoked key configs and their rotation periods,
+// even when the local seeds JSON still contains them.
+func dropRevokedOHTTPKeys(list *ohttpRevocationList, keyConfigs ohttp.KeyConfigs, rotationPeriods []gateway.KeyRotationPeriodWithID) (ohttp.KeyConfigs, []gateway.KeyRotationPeriodWithID, error) {
+	if list == nil {
+		return keyConfigs, rotationPeriods, nil
+	}
+	var revokedIDs []byte
+	kept := make(ohttp.KeyConfigs, 0, len(keyConfigs))
+	for _, kc := range keyConfigs {
+		entry, revoked, err := list.revokes(kc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to fingerprint key_id %d: %w", kc.KeyID, err)
+		}
+		if revoked {
+			fmt.Fprintf(os.Stderr, "Rejecting revoked OHTTP key_id %d (%s)\n", kc.KeyID, firstNonEmpty(entry.Reason, "no reason given"))
+			revokedIDs = append(revokedIDs, kc.KeyID)
+			continue
+		}
+		kept = append(kept, kc)
+	}
+	periods := slices.DeleteFunc(slices.Clone(rotationPeriods), func(p gateway.KeyRotationPeriodWithID) bool {
+		return slices.Contains(revokedIDs, p.KeyID)
+	})
+	if len(kept) == 0 {
+		return nil, nil, errors.New("every OHTTP key is revoked")
+	}
+	return kept, periods, nil
+}
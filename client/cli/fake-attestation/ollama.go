@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+func writeOllamaError(w http.ResponseWriter, status int, message string) {
+	writeProxyJSON(w, status, map[string]string{"error": message})
+}
+
+// ollamaPassthrough forwards an Ollama API call unchanged, except that a
+// missing model is set to the default one. The upstream response, streamed
+// NDJSON included, is copied back as it arrives.
+func (p *proxyServer) ollamaPassthrough(w http.ResponseWriter, r *http.Request) {
+	var body map[string]json.RawMessage
+	if err := decodeProxyRequest(w, r, &body); err != nil {
+		writeOllamaError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var model string
+	if raw, ok := body["model"]; ok {
+		if err := json.Unmarshal(raw, &model); err != nil {
+			writeOllamaError(w, http.StatusBadRequest, "model must be a string")
+			return
+		}
+	}
+	if strings.TrimSpace(model) == "" {
+		model = p.model
+		body["model"], _ = json.Marshal(model)
+	}
+
+	resp, err := p.upstream(r.Context(), r.URL.Path, model, body)
+	if err != nil {
+		writeOllamaError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(resp.StatusCode)
+	flusher := http.NewResponseController(w)
+	buf := make([]byte, 32<<10)
+	for {
+		n, readErr := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+			if err := flusher.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return
+			}
+		}
+		if readErr != nil {
+			if readErr != io.EOF {
+				// Too late for a status code; NDJSON readers see an error line.
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": readErr.Error()})
+			}
+			return
+		}
+	}
+}
+
+// ollamaTags lists the models the router's nodes serve, like /v1/models.
+func (p *proxyServer) ollamaTags(w http.ResponseWriter, r *http.Request) {
+	names, err := p.listModels(r.Context())
+	if err != nil {
+		writeOllamaError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	models := []map[string]any{}
+	for _, model := range names {
+		models = append(models, map[string]any{
+			"name":        model,
+			"model":       model,
+			"modified_at": p.started.UTC(),
+			"size":        0,
+			"digest":      "",
+			"details":     map[string]any{},
+		})
+	}
+	writeProxyJSON(w, http.StatusOK, map[string]any{"models": models})
+}
+
+// ollamaRoot answers the liveness probe Ollama clients send to "/".
+func (p *proxyServer) ollamaRoot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(w, "Ollama is running")
+}
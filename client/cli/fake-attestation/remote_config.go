@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/openpcc/ohttp"
+	"github.com/openpcc/openpcc/gateway"
+)
+
+// The server-3 /api/config schema this client understands. See
+// server-3/README.md.
+const (
+	remoteConfigVersion       = "0.002"
+	remoteKeyBundleFormat     = "openpcc.ohttp.keybundle.v0.002"
+	remoteKeyFormatSeedDigest = "sha256-seed"
+	maxRemoteConfigBytes      = 1 << 20
+)
+
+type remoteConfigFeatures struct {
+	OHTTP           *bool `json:"ohttp"`
+	RealAttestation *bool `json:"real_attestation"`
+}
+
+type remoteAttestation struct {
+	PolicyID      string         `json:"policy_id"`
+	Allowed       map[string]any `json:"allowed"`
+	VerifierHints map[string]any `json:"verifier_hints"`
+}
+
+type remoteConfigPayload struct {
+	Version                 string                `json:"version"`
+	Features                *remoteConfigFeatures `json:"features"`
+	RelayURLs               []string              `json:"relay_urls"`
+	GatewayURL              string                `json:"gateway_url"`
+	RouterURL               string                `json:"router_url"`
+	OHTTPKeyConfigsBundle   string                `json:"ohttp_key_configs_bundle"`
+	OHTTPKeyRotationPeriods []ohttpKeyPeriodSpec  `json:"ohttp_key_rotation_periods"`
+	Attestation             remoteAttestation     `json:"attestation"`
+}
+
+type remoteBundleKey struct {
+	KeyID           string `json:"key_id"`
+	PublicKeyB64    string `json:"public_key_b64"`
+	PublicKeyFormat string `json:"public_key_format"`
+}
+
+type remoteKeyBundle struct {
+	Format string            `json:"format"`
+	Keys   []remoteBundleKey `json:"keys"`
+}
+
+// remoteClientConfig is a validated server-3 payload.
+type remoteClientConfig struct {
+	URL             string
+	OHTTP           bool
+	RealAttestation bool
+	RelayURLs       []string
+	RouterURL       string
+	Keys            []remoteBundleKey
+	Periods         map[byte]ohttpKeyPeriodSpec
+	Attestation     remoteAttestation
+}
+
+// fetchRemoteConfig downloads and validates the server-3 client config.
+func fetchRemoteConfig(ctx context.Context, client *http.Client, configURL string) (*remoteClientConfig, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, configURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteConfigBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxRemoteConfigBytes {
+		return nil, fmt.Errorf("config larger than %d bytes", maxRemoteConfigBytes)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return parseRemoteConfig(configURL, body)
+}
+
+func parseRemoteConfig(configURL string, raw []byte) (*remoteClientConfig, error) {
+	var payload remoteConfigPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("invalid config JSON: %w", err)
+	}
+	if payload.Version != remoteConfigVersion {
+		return nil, fmt.Errorf("unsupported config schema version %q (this client supports %q)", payload.Version, remoteConfigVersion)
+	}
+	if payload.Features == nil || payload.Features.OHTTP == nil || payload.Features.RealAttestation == nil {
+		return nil, errors.New("features.ohttp and features.real_attestation are required")
+	}
+
+	cfg := &remoteClientConfig{
+		URL:             configURL,
+		OHTTP:           *payload.Features.OHTTP,
+		RealAttestation: *payload.Features.RealAttestation,
+		RouterURL:       strings.TrimSpace(payload.RouterURL),
+		Attestation:     payload.Attestation,
+	}
+	if cfg.RealAttestation && strings.TrimSpace(payload.Attestation.PolicyID) == "" {
+		return nil, errors.New("attestation.policy_id is required when features.real_attestation is true")
+	}
+	if !cfg.OHTTP {
+		return cfg, nil
+	}
+
+	if len(payload.RelayURLs) == 0 {
+		return nil, errors.New("relay_urls must not be empty when features.ohttp is true")
+	}
+	for idx, raw := range payload.RelayURLs {
+		parsed, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("relay_urls[%d] is not an http(s) URL: %q", idx, raw)
+		}
+		cfg.RelayURLs = append(cfg.RelayURLs, parsed.String())
+	}
+
+	bundleJSON, err := base64.StdEncoding.DecodeString(payload.OHTTPKeyConfigsBundle)
+	if err != nil {
+		return nil, fmt.Errorf("ohttp_key_configs_bundle is not base64: %w", err)
+	}
+	var bundle remoteKeyBundle
+	if err := json.Unmarshal(bundleJSON, &bundle); err != nil {
+		return nil, fmt.Errorf("invalid ohttp_key_configs_bundle: %w", err)
+	}
+	if bundle.Format != remoteKeyBundleFormat {
+		return nil, fmt.Errorf("unsupported key bundle format %q (this client supports %q)", bundle.Format, remoteKeyBundleFormat)
+	}
+	if len(bundle.Keys) == 0 {
+		return nil, errors.New("key bundle has no keys")
+	}
+
+	cfg.Periods, err = indexOHTTPKeyPeriods(payload.OHTTPKeyRotationPeriods, "ohttp_key_rotation_periods")
+	if err != nil {
+		return nil, err
+	}
+	for idx, key := range bundle.Keys {
+		keyID, err := parseKeyID(key.KeyID)
+		if err != nil {
+			return nil, fmt.Errorf("key bundle keys[%d].key_id invalid: %w", idx, err)
+		}
+		if _, ok := cfg.Periods[keyID]; !ok {
+			return nil, fmt.Errorf("key bundle key_id %s has no rotation period", key.KeyID)
+		}
+		if key.PublicKeyFormat != remoteKeyFormatSeedDigest {
+			return nil, fmt.Errorf("key bundle key_id %s uses unsupported public_key_format %q", key.KeyID, key.PublicKeyFormat)
+		}
+		if digest, err := base64.StdEncoding.DecodeString(key.PublicKeyB64); err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("key bundle key_id %s public_key_b64 is not a base64 sha256 digest", key.KeyID)
+		}
+	}
+	cfg.Keys = bundle.Keys
+	return cfg, nil
+}
+
+// seedKeyMaterial builds key configs for the bundle keys from dev-only local
+// seeds. A v0.002 bundle only carries SHA256(seed || key_id) digests, not KEM
+// public keys, so each seed must match the digest server-3 published for it.
+// Rotation periods always come from server-3.
+func (c *remoteClientConfig) seedKeyMaterial(seeds []ohttpSeedSpec) (ohttp.KeyConfigs, []gateway.KeyRotationPeriodWithID, error) {
+	var verified []ohttpSeedSpec
+	for _, key := range c.Keys {
+		keyID, _ := parseKeyID(key.KeyID)
+		seed, ok := localSeedForKeyID(seeds, keyID)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Skipping server-3 key_id %s: no local seed to check its %s digest against\n", key.KeyID, remoteKeyFormatSeedDigest)
+			continue
+		}
+		seedBytes, err := hex.DecodeString(strings.TrimSpace(seed.SeedHex))
+		if err != nil {
+			return nil, nil, fmt.Errorf("local seed for key_id %s invalid: %w", key.KeyID, err)
+		}
+		want, _ := base64.StdEncoding.DecodeString(key.PublicKeyB64)
+		got := sha256.Sum256(append(seedBytes, bundleKeyIDBytes(key.KeyID)...))
+		if subtle.ConstantTimeCompare(got[:], want) != 1 {
+			return nil, nil, fmt.Errorf("local seed for key_id %s does not match the server-3 key bundle", key.KeyID)
+		}
+
+		period := c.Periods[keyID]
+		seed.ActiveFrom = period.ActiveFrom
+		seed.ActiveUntil = period.ActiveUntil
+		verified = append(verified, seed)
+	}
+	if len(verified) == 0 {
+		return nil, nil, errors.New("no server-3 key matches a local seed")
+	}
+	return buildOHTTPKeyMaterial(verified)
+}
+
+func localSeedForKeyID(seeds []ohttpSeedSpec, keyID byte) (ohttpSeedSpec, bool) {
+	for _, seed := range seeds {
+		if id, err := parseKeyID(seed.KeyID); err == nil && id == keyID {
+			return seed, true
+		}
+	}
+	return ohttpSeedSpec{}, false
+}
+
+// bundleKeyIDBytes encodes a key_id the way server-3 does when it derives the
+// bundle digest: hex when it decodes as hex, UTF-8 otherwise.
+func bundleKeyIDBytes(keyID string) []byte {
+	if len(keyID)%2 == 0 {
+		if decoded, err := hex.DecodeString(keyID); err == nil {
+			return decoded
+		}
+	}
+	return []byte(keyID)
+}
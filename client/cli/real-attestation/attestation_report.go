@@ -0,0 +1,319 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/openpcc/openpcc"
+	ev "github.com/openpcc/openpcc/attestation/evidence"
+	"github.com/openpcc/openpcc/transparency"
+	"github.com/openpcc/openpcc/transparency/statements"
+	bundlepb "github.com/sigstore/protobuf-specs/gen/pb-go/bundle/v1"
+	"github.com/sigstore/sigstore-go/pkg/bundle"
+	"github.com/sigstore/sigstore-go/pkg/fulcio/certificate"
+	"google.golang.org/protobuf/proto"
+)
+
+// attestationReport is the --attestation-report file: what the client
+// verified about the nodes that could have served the request. openpcc does
+// not say which candidate answered, so every cached node verified for the
+// request's tags is listed.
+type attestationReport struct {
+	GeneratedAt  time.Time          `json:"generated_at"`
+	Request      reportRequest      `json:"request"`
+	Transparency reportTransparency `json:"transparency"`
+	Nodes        []reportNode       `json:"nodes"`
+}
+
+type reportRequest struct {
+	Model               string    `json:"model"`
+	NodeTags            []string  `json:"node_tags"`
+	OHTTP               bool      `json:"ohttp"`
+	Status              int       `json:"status"`
+	StartedAt           time.Time `json:"started_at"`
+	FinishedAt          time.Time `json:"finished_at"`
+	AttestationPolicyID string    `json:"attestation_policy_id,omitempty"`
+}
+
+type reportTransparency struct {
+	Environment          string                      `json:"sigstore_environment"`
+	TrustedRootCachePath string                      `json:"trusted_root_cache_path,omitempty"`
+	IdentityPolicy       transparency.IdentityPolicy `json:"identity_policy"`
+	IdentityPolicySource string                      `json:"identity_policy_source"`
+}
+
+type reportNode struct {
+	ID         string           `json:"id"`
+	Tags       []string         `json:"tags"`
+	VerifiedAt time.Time        `json:"verified_at"`
+	TEE        string           `json:"tee"`
+	Evidence   []reportEvidence `json:"evidence"`
+	TPM        reportTPM        `json:"tpm"`
+	REK        reportREK        `json:"rek"`
+	Image      reportImage      `json:"image_bundle"`
+}
+
+type reportEvidence struct {
+	Type            string `json:"type"`
+	DataBytes       int    `json:"data_bytes"`
+	DataSHA256      string `json:"data_sha256"`
+	SignatureSHA256 string `json:"signature_sha256,omitempty"`
+}
+
+type reportPCR struct {
+	Index  uint32 `json:"index"`
+	SHA256 string `json:"sha256"`
+}
+
+type reportTPM struct {
+	QuoteSHA256          string      `json:"quote_sha256,omitempty"`
+	QuoteSignatureSHA256 string      `json:"quote_signature_sha256,omitempty"`
+	PCRs                 []reportPCR `json:"pcrs"`
+	TPMTPublicSHA256     string      `json:"tpmt_public_sha256,omitempty"`
+}
+
+type reportREK struct {
+	KEM                  uint16 `json:"kem"`
+	KDF                  uint16 `json:"kdf"`
+	AEAD                 uint16 `json:"aead"`
+	PublicKeySHA256      string `json:"public_key_sha256"`
+	PublicKeyFingerprint string `json:"public_key_fingerprint"`
+}
+
+// reportImage is the image sigstore bundle, verified again for the report
+// against the identity policy. Bundle is the raw protobuf bundle, so the
+// check can be repeated with other sigstore tools.
+type reportImage struct {
+	Bundle                 []byte    `json:"bundle"`
+	BundleSHA256           string    `json:"bundle_sha256"`
+	Verified               bool      `json:"verified"`
+	Error                  string    `json:"error,omitempty"`
+	SignedAt               time.Time `json:"signed_at,omitzero"`
+	OIDCIssuer             string    `json:"oidc_issuer,omitempty"`
+	OIDCSubject            string    `json:"oidc_subject,omitempty"`
+	CertificateIssuer      string    `json:"certificate_issuer,omitempty"`
+	SourceRepositoryURI    string    `json:"source_repository_uri,omitempty"`
+	SourceRepositoryDigest string    `json:"source_repository_digest,omitempty"`
+	BuildSignerURI         string    `json:"build_signer_uri,omitempty"`
+	RunInvocationURI       string    `json:"run_invocation_uri,omitempty"`
+	Name                   string    `json:"name,omitempty"`
+	ArtifactID             string    `json:"artifact_id,omitempty"`
+	BuildTime              int64     `json:"build_time,omitempty"`
+}
+
+func sha256Hex(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// colonFingerprint formats a SHA-256 digest as colon-separated hex pairs.
+func colonFingerprint(data []byte) string {
+	sum := sha256.Sum256(data)
+	pairs := make([]string, len(sum))
+	for idx, b := range sum {
+		pairs[idx] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(pairs, ":")
+}
+
+func findEvidence(list ev.SignedEvidenceList, evidenceType ev.EvidenceType) *ev.SignedEvidencePiece {
+	for _, piece := range list {
+		if piece.Type == evidenceType {
+			return piece
+		}
+	}
+	return nil
+}
+
+func reportTEE(list ev.SignedEvidenceList) string {
+	switch {
+	case findEvidence(list, ev.TdxReport) != nil:
+		return "tdx"
+	case findEvidence(list, ev.SevSnpReport) != nil:
+		return "sev-snp"
+	case findEvidence(list, ev.SevSnpExtendedReport) != nil:
+		return "sev-snp-extended"
+	default:
+		return "none"
+	}
+}
+
+func newReportTPM(list ev.SignedEvidenceList) (reportTPM, error) {
+	tpm := reportTPM{PCRs: []reportPCR{}}
+	if piece := findEvidence(list, ev.TpmtPublic); piece != nil {
+		tpm.TPMTPublicSHA256 = sha256Hex(piece.Data)
+	}
+	piece := findEvidence(list, ev.TpmQuote)
+	if piece == nil {
+		return tpm, errors.New("no TPM quote in evidence")
+	}
+	var quote ev.TPMQuoteAttestation
+	if err := quote.UnmarshalBinary(piece.Data); err != nil {
+		return tpm, fmt.Errorf("failed to decode TPM quote: %w", err)
+	}
+	tpm.QuoteSHA256 = sha256Hex(quote.TmpstAttestQuote)
+	tpm.QuoteSignatureSHA256 = sha256Hex(piece.Signature)
+	if quote.PCRValues != nil {
+		for index, value := range quote.PCRValues.Values {
+			tpm.PCRs = append(tpm.PCRs, reportPCR{Index: index, SHA256: hex.EncodeToString(value)})
+		}
+	}
+	slices.SortFunc(tpm.PCRs, func(a, b reportPCR) int { return int(a.Index) - int(b.Index) })
+	return tpm, nil
+}
+
+// newReportImage verifies the image bundle against policy and reads the
+// signer identity from its Fulcio certificate.
+func newReportImage(list ev.SignedEvidenceList, verifier *transparency.Verifier, policy transparency.IdentityPolicy) reportImage {
+	piece := findEvidence(list, ev.ImageSigstoreBundle)
+	if piece == nil {
+		return reportImage{Error: "no image sigstore bundle in evidence"}
+	}
+	image := reportImage{Bundle: piece.Data, BundleSHA256: sha256Hex(piece.Data)}
+
+	statement, meta, err := statements.VerifyImageManifestBundle(piece.Data, verifier, policy)
+	if err != nil {
+		image.Error = err.Error()
+		return image
+	}
+	image.Verified = true
+	image.SignedAt = meta.Timestamp
+	if manifest, err := statements.ToImageManifest(statement); err == nil {
+		image.Name, image.ArtifactID, image.BuildTime = manifest.Name, manifest.ArtifactID, manifest.BuildTime
+	}
+
+	summary, err := bundleCertificateSummary(piece.Data)
+	if err != nil {
+		image.Error = fmt.Sprintf("bundle verified, but its certificate could not be read: %v", err)
+		return image
+	}
+	image.OIDCIssuer = summary.Issuer
+	image.OIDCSubject = summary.SubjectAlternativeName
+	image.CertificateIssuer = summary.CertificateIssuer
+	image.SourceRepositoryURI = summary.SourceRepositoryURI
+	image.SourceRepositoryDigest = summary.SourceRepositoryDigest
+	image.BuildSignerURI = summary.BuildSignerURI
+	image.RunInvocationURI = summary.RunInvocationURI
+	return image
+}
+
+func bundleCertificateSummary(data []byte) (certificate.Summary, error) {
+	bpb := &bundlepb.Bundle{}
+	if err := proto.Unmarshal(data, bpb); err != nil {
+		return certificate.Summary{}, err
+	}
+	b, err := bundle.NewBundle(bpb)
+	if err != nil {
+		return certificate.Summary{}, err
+	}
+	content, err := b.VerificationContent()
+	if err != nil {
+		return certificate.Summary{}, err
+	}
+	cert := content.Certificate()
+	if cert == nil {
+		return certificate.Summary{}, errors.New("bundle is signed with a public key, not a certificate")
+	}
+	return certificate.SummarizeCertificate(cert)
+}
+
+func newReportNode(node openpcc.VerifiedNode, verifier *transparency.Verifier, policy transparency.IdentityPolicy) (reportNode, error) {
+	list := node.Manifest.Evidence
+	out := reportNode{
+		ID:         node.Manifest.ID.String(),
+		Tags:       node.Manifest.Tags.Slice(),
+		VerifiedAt: node.VerifiedAt.UTC(),
+		TEE:        reportTEE(list),
+		Evidence:   make([]reportEvidence, 0, len(list)),
+		REK: reportREK{
+			KEM:                  uint16(node.TrustedData.KEM),
+			KDF:                  uint16(node.TrustedData.KDF),
+			AEAD:                 uint16(node.TrustedData.AEAD),
+			PublicKeySHA256:      sha256Hex(node.TrustedData.PublicKey),
+			PublicKeyFingerprint: colonFingerprint(node.TrustedData.PublicKey),
+		},
+	}
+	slices.Sort(out.Tags)
+	for _, piece := range list {
+		out.Evidence = append(out.Evidence, reportEvidence{
+			Type:            piece.Type.String(),
+			DataBytes:       len(piece.Data),
+			DataSHA256:      sha256Hex(piece.Data),
+			SignatureSHA256: sha256Hex(piece.Signature),
+		})
+	}
+	tpm, err := newReportTPM(list)
+	out.TPM = tpm
+	if err != nil {
+		return out, err
+	}
+	out.Image = newReportImage(list, verifier, policy)
+	if !out.Image.Verified {
+		return out, fmt.Errorf("image bundle: %s", out.Image.Error)
+	}
+	return out, nil
+}
+
+// writeAttestationReport writes the report for request to path. The file is
+// written even when a node fails the report's checks, and the error then
+// names the failing nodes.
+func writeAttestationReport(path string, nodes []openpcc.VerifiedNode, request reportRequest, cfg transparency.VerifierConfig, httpClient *http.Client, policy transparency.IdentityPolicy, policySource string) error {
+	report := attestationReport{
+		GeneratedAt: time.Now().UTC(),
+		Request:     request,
+		Transparency: reportTransparency{
+			Environment:          string(cfg.Environment),
+			TrustedRootCachePath: cfg.LocalTrustedRootCachePath,
+			IdentityPolicy:       policy,
+			IdentityPolicySource: policySource,
+		},
+		Nodes: []reportNode{},
+	}
+
+	var candidates []openpcc.VerifiedNode
+	for _, node := range nodes {
+		if slices.ContainsFunc(request.NodeTags, func(tag string) bool { return !node.Manifest.Tags.Contains(tag) }) {
+			continue
+		}
+		candidates = append(candidates, node)
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("no verified nodes cached for tags %s", strings.Join(request.NodeTags, ","))
+	}
+
+	verifier, err := transparency.NewVerifier(cfg, httpClient)
+	if err != nil {
+		return fmt.Errorf("failed to create transparency verifier: %w", err)
+	}
+	var failed []string
+	for _, node := range candidates {
+		out, err := newReportNode(node, verifier, policy)
+		if err != nil {
+			failed = append(failed, out.ID+": "+err.Error())
+		}
+		report.Nodes = append(report.Nodes, out)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d node(s) failed verification: %s", len(failed), strings.Join(failed, "; "))
+	}
+	return nil
+}
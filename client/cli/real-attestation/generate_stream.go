@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const maxStreamLineBytes = 1 << 20
+
+// chatMessage is one entry of an Ollama /api/chat conversation.
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// generateChunk is one line of an Ollama /api/generate or /api/chat NDJSON
+// stream. The stats fields are only set on the final chunk, which has Done
+// set.
+type generateChunk struct {
+	Model              string       `json:"model"`
+	Response           string       `json:"response,omitempty"`
+	Message            *chatMessage `json:"message,omitempty"`
+	Done               bool         `json:"done"`
+	DoneReason         string       `json:"done_reason"`
+	Error              string       `json:"error,omitempty"`
+	TotalDuration      int64        `json:"total_duration"`
+	LoadDuration       int64        `json:"load_duration"`
+	PromptEvalCount    int          `json:"prompt_eval_count"`
+	PromptEvalDuration int64        `json:"prompt_eval_duration"`
+	EvalCount          int          `json:"eval_count"`
+	EvalDuration       int64        `json:"eval_duration"`
+}
+
+// streamGenerate writes the response tokens to out as they arrive and
+// returns the final chunk.
+func streamGenerate(body io.Reader, out io.Writer) (generateChunk, error) {
+	return decodeStream(body, func(chunk generateChunk) error {
+		_, err := io.WriteString(out, chunk.text())
+		return err
+	})
+}
+
+// decodeStream calls fn for every chunk of an NDJSON stream, including the
+// final one, and returns the final chunk.
+func decodeStream(body io.Reader, fn func(generateChunk) error) (generateChunk, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxStreamLineBytes)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var chunk generateChunk
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			return generateChunk{}, fmt.Errorf("invalid stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return generateChunk{}, fmt.Errorf("server error: %s", chunk.Error)
+		}
+		if err := fn(chunk); err != nil {
+			return generateChunk{}, err
+		}
+		if chunk.Done {
+			return chunk, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return generateChunk{}, err
+	}
+	return generateChunk{}, errors.New("stream ended before the done chunk")
+}
+
+// text is the generated text in the chunk, from /api/generate or /api/chat.
+func (c generateChunk) text() string {
+	if c.Message != nil {
+		return c.Message.Content
+	}
+	return c.Response
+}
+
+// stats formats the timings of a done chunk. Durations are in nanoseconds.
+func (c generateChunk) stats() string {
+	parts := []string{fmt.Sprintf("done_reason=%s", firstNonEmpty(c.DoneReason, "stop"))}
+	if c.PromptEvalCount > 0 {
+		parts = append(parts, fmt.Sprintf("prompt_tokens=%d", c.PromptEvalCount))
+	}
+	if c.EvalCount > 0 {
+		parts = append(parts, fmt.Sprintf("tokens=%d", c.EvalCount))
+		if c.EvalDuration > 0 {
+			parts = append(parts, fmt.Sprintf("tokens_per_second=%.1f", float64(c.EvalCount)/time.Duration(c.EvalDuration).Seconds()))
+		}
+	}
+	if c.LoadDuration > 0 {
+		parts = append(parts, fmt.Sprintf("load=%s", time.Duration(c.LoadDuration).Round(time.Millisecond)))
+	}
+	if c.TotalDuration > 0 {
+		parts = append(parts, fmt.Sprintf("total=%s", time.Duration(c.TotalDuration).Round(time.Millisecond)))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	// Keys before the first section header belong to defaultINISection too.
+	defaultINISection = "default"
+	iniProfilePrefix  = "profile "
+	// maxINILineBytes leaves room for seed or key JSON kept on one line.
+	maxINILineBytes = 1 << 20
+)
+
+// iniFile is a parsed hybrid.ini: the settings of each section, keyed by
+// the lower-cased section name with its whitespace collapsed, e.g.
+// "profile staging".
+type iniFile map[string]map[string]string
+
+// parseINI reads key=value settings grouped in [section]s. A value in single
+// or double quotes is kept as is, including "#" and ";", and may span lines
+// until its closing quote. Unquoted values end at a "#" or ";" that starts
+// the value or follows whitespace. Empty values leave the key unset.
+func parseINI(r io.Reader) (iniFile, error) {
+	file := iniFile{}
+	section := defaultINISection
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxINILineBytes)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			name, rest, ok := strings.Cut(line[1:], "]")
+			if !ok || !isINICommentOrEmpty(rest) {
+				return nil, fmt.Errorf("line %d: invalid section header %q", lineNo, line)
+			}
+			section = normalizeINISection(name)
+			if file[section] == nil {
+				file[section] = map[string]string{}
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if !ok || key == "" {
+			continue
+		}
+		start := lineNo
+		value = strings.TrimSpace(value)
+		if value != "" && (value[0] == '"' || value[0] == '\'') {
+			quote := value[:1]
+			text := value[1:]
+			for !strings.Contains(text, quote) {
+				if !scanner.Scan() {
+					return nil, fmt.Errorf("line %d: unterminated quoted value for %s", start, key)
+				}
+				lineNo++
+				text += "\n" + scanner.Text()
+			}
+			end := strings.Index(text, quote)
+			if !isINICommentOrEmpty(text[end+1:]) {
+				return nil, fmt.Errorf("line %d: unexpected text after quoted value for %s", lineNo, key)
+			}
+			value = text[:end]
+		} else {
+			value = stripInlineComment(value)
+		}
+		if value == "" {
+			continue
+		}
+		if file[section] == nil {
+			file[section] = map[string]string{}
+		}
+		file[section][key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func normalizeINISection(name string) string {
+	name = strings.ToLower(strings.Join(strings.Fields(name), " "))
+	if name == "" {
+		return defaultINISection
+	}
+	return name
+}
+
+func isINICommentOrEmpty(rest string) bool {
+	rest = strings.TrimSpace(rest)
+	return rest == "" || rest[0] == '#' || rest[0] == ';'
+}
+
+// stripInlineComment cuts an unquoted value at a "#" or ";" that starts it
+// or follows whitespace, so regexes and URL fragments survive.
+func stripInlineComment(value string) string {
+	for idx := 0; idx < len(value); idx++ {
+		if value[idx] != '#' && value[idx] != ';' {
+			continue
+		}
+		if idx == 0 || value[idx-1] == ' ' || value[idx-1] == '\t' {
+			return strings.TrimSpace(value[:idx])
+		}
+	}
+	return value
+}
+
+// profiles returns the names of the [profile NAME] sections.
+func (f iniFile) profiles() []string {
+	var names []string
+	for section := range f {
+		if name, ok := strings.CutPrefix(section, iniProfilePrefix); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// unknownSections returns the sections other than [default] and
+// [profile NAME] that have settings, in name order.
+func (f iniFile) unknownSections() []string {
+	var names []string
+	for section, settings := range f {
+		if section == defaultINISection || strings.HasPrefix(section, iniProfilePrefix) || len(settings) == 0 {
+			continue
+		}
+		names = append(names, section)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// configFile is one layer of hybrid.ini settings.
+type configFile struct {
+	Path string
+	// Required is set for the file named by -config or HYBRID_CONFIG, which
+	// must exist. The system and user files are optional.
+	Required bool
+}
+
+func readINIFile(f configFile) (iniFile, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		if os.IsNotExist(err) && !f.Required {
+			return iniFile{}, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	parsed, err := parseINI(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Path, err)
+	}
+	return parsed, nil
+}
+
+type configEntry struct {
+	Value string
+	// Source names the file, and profile, the value came from, as in
+	// "config /etc/nnstreamer/hybrid.ini [profile staging]".
+	Source string
+}
+
+// hybridConfig is the merged settings of the config files.
+type hybridConfig struct {
+	files   []configFile
+	entries map[string]configEntry
+	// ignored lists the sections with settings that are neither [default]
+	// nor a [profile NAME], e.g. "[staging] in /etc/nnstreamer/hybrid.ini".
+	ignored []string
+}
+
+// loadConfig merges files in order, later files overriding earlier ones. The
+// [default] settings of every file come first and the selected profile's
+// after them, so a profile overrides the defaults of all files.
+func loadConfig(files []configFile, profile string) (hybridConfig, error) {
+	config := hybridConfig{files: files, entries: map[string]configEntry{}}
+	parsed := make([]iniFile, 0, len(files))
+	for _, f := range files {
+		values, err := readINIFile(f)
+		if err != nil {
+			return hybridConfig{}, err
+		}
+		parsed = append(parsed, values)
+		for _, section := range values.unknownSections() {
+			config.ignored = append(config.ignored, fmt.Sprintf("[%s] in %s", section, f.Path))
+		}
+	}
+	for idx, values := range parsed {
+		config.merge(values[defaultINISection], "config "+files[idx].Path)
+	}
+	if profile == "" {
+		return config, nil
+	}
+
+	section := iniProfilePrefix + normalizeINISection(profile)
+	found := false
+	var available []string
+	for idx, values := range parsed {
+		available = appendMissing(available, values.profiles()...)
+		if settings, ok := values[section]; ok {
+			found = true
+			config.merge(settings, fmt.Sprintf("config %s [%s]", files[idx].Path, section))
+		}
+	}
+	if !found {
+		slices.Sort(available)
+		return hybridConfig{}, fmt.Errorf("profile %q not found in %s (available: %s)",
+			profile, config.paths(), firstNonEmpty(strings.Join(available, ", "), "none"))
+	}
+	return config, nil
+}
+
+func (c hybridConfig) merge(values map[string]string, source string) {
+	for key, value := range values {
+		c.entries[key] = configEntry{Value: value, Source: source}
+	}
+}
+
+// lookup returns the first of keys that is set, and its source.
+func (c hybridConfig) lookup(keys ...string) (string, string) {
+	for _, key := range keys {
+		if entry, ok := c.entries[strings.ToLower(key)]; ok && strings.TrimSpace(entry.Value) != "" {
+			return strings.TrimSpace(entry.Value), entry.Source
+		}
+	}
+	return "", ""
+}
+
+// value returns the setting of key, or "".
+func (c hybridConfig) value(key string) string {
+	value, _ := c.lookup(key)
+	return value
+}
+
+// sources returns the distinct sources of the keys that are set.
+func (c hybridConfig) sources(keys ...string) string {
+	var sources []string
+	for _, key := range keys {
+		if _, source := c.lookup(key); source != "" {
+			sources = appendMissing(sources, source)
+		}
+	}
+	return strings.Join(sources, ", ")
+}
+
+// paths lists the config files, for messages.
+func (c hybridConfig) paths() string {
+	paths := make([]string, 0, len(c.files))
+	for _, f := range c.files {
+		paths = append(paths, f.Path)
+	}
+	return strings.Join(paths, ", ")
+}
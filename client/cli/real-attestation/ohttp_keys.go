@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/openpcc/ohttp"
+	"github.com/openpcc/openpcc/gateway"
+	"github.com/openpcc/openpcc/keyrotation"
+)
+
+// ohttpKeysMediaType is the RFC 9458 key configuration list format, as served
+// by mem-gateway at /.well-known/ohttp-gateway (RFC 9540).
+const ohttpKeysMediaType = "application/ohttp-keys"
+
+const maxOHTTPKeysBytes = 64 << 10
+
+// ohttpKeyPeriodSpec is the rotation period of one key. The shape matches
+// server-3's ohttp_key_rotation_periods and mem-gateway's published periods.
+type ohttpKeyPeriodSpec struct {
+	KeyID       string `json:"key_id"`
+	ActiveFrom  string `json:"active_from"`
+	ActiveUntil string `json:"active_until"`
+}
+
+// ohttpKeySource names where the public key configs come from: a file with
+// the raw application/ohttp-keys bytes, those bytes in base64, or a URL that
+// serves them.
+type ohttpKeySource struct {
+	Kind   string // "file", "base64" or "url"
+	Value  string
+	Source string
+}
+
+func (s ohttpKeySource) String() string {
+	if s.Kind == "base64" {
+		return fmt.Sprintf("%s, base64", s.Source)
+	}
+	return fmt.Sprintf("%s, %s %s", s.Source, s.Kind, s.Value)
+}
+
+// loadOHTTPKeyConfigs reads an application/ohttp-keys list from src.
+func loadOHTTPKeyConfigs(ctx context.Context, client *http.Client, src ohttpKeySource) (ohttp.KeyConfigs, error) {
+	var raw []byte
+	var err error
+	switch src.Kind {
+	case "file":
+		raw, err = os.ReadFile(src.Value)
+	case "base64":
+		value := strings.TrimSpace(src.Value)
+		raw, err = base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			raw, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+		}
+		if err != nil {
+			err = errors.New("value is not base64")
+		}
+	case "url":
+		raw, err = fetchOHTTPResource(ctx, client, src.Value, ohttpKeysMediaType)
+	default:
+		err = fmt.Errorf("unknown key source %q", src.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var keyConfigs ohttp.KeyConfigs
+	if err := keyConfigs.UnmarshalBinary(raw); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ohttpKeysMediaType, err)
+	}
+	if len(keyConfigs) == 0 {
+		return nil, errors.New("key config list is empty")
+	}
+	return keyConfigs, nil
+}
+
+// loadOHTTPKeyPeriods reads rotation periods from inline JSON, an http(s) URL
+// or a file. Both a bare list and {"ohttp_key_rotation_periods": [...]} are
+// accepted.
+func loadOHTTPKeyPeriods(ctx context.Context, client *http.Client, value string) (map[byte]ohttpKeyPeriodSpec, error) {
+	value = strings.TrimSpace(value)
+	var raw []byte
+	var err error
+	switch {
+	case strings.HasPrefix(value, "[") || strings.HasPrefix(value, "{"):
+		raw = []byte(value)
+	case strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://"):
+		raw, err = fetchOHTTPResource(ctx, client, value, "application/json")
+	default:
+		raw, err = os.ReadFile(value)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []ohttpKeyPeriodSpec
+	if err := json.Unmarshal(raw, &list); err != nil {
+		var envelope struct {
+			Periods []ohttpKeyPeriodSpec `json:"ohttp_key_rotation_periods"`
+		}
+		if err := json.Unmarshal(raw, &envelope); err != nil {
+			return nil, fmt.Errorf("invalid rotation periods JSON: %w", err)
+		}
+		list = envelope.Periods
+	}
+	return indexOHTTPKeyPeriods(list, "periods")
+}
+
+func indexOHTTPKeyPeriods(list []ohttpKeyPeriodSpec, label string) (map[byte]ohttpKeyPeriodSpec, error) {
+	periods := make(map[byte]ohttpKeyPeriodSpec, len(list))
+	for idx, period := range list {
+		keyID, err := parseKeyID(period.KeyID)
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d].key_id invalid: %w", label, idx, err)
+		}
+		if _, err := time.Parse(time.RFC3339, period.ActiveFrom); err != nil {
+			return nil, fmt.Errorf("%s[%d].active_from invalid: %w", label, idx, err)
+		}
+		if _, err := time.Parse(time.RFC3339, period.ActiveUntil); err != nil {
+			return nil, fmt.Errorf("%s[%d].active_until invalid: %w", label, idx, err)
+		}
+		if _, ok := periods[keyID]; ok {
+			return nil, fmt.Errorf("%s[%d].key_id %d is duplicated", label, idx, keyID)
+		}
+		periods[keyID] = period
+	}
+	return periods, nil
+}
+
+func fetchOHTTPResource(ctx context.Context, client *http.Client, resourceURL, mediaType string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, resourceURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", mediaType)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxOHTTPKeysBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxOHTTPKeysBytes {
+		return nil, fmt.Errorf("%s returned more than %d bytes", resourceURL, maxOHTTPKeysBytes)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", resourceURL, resp.Status)
+	}
+	if got, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); got != mediaType {
+		return nil, fmt.Errorf("%s returned Content-Type %q, want %s", resourceURL, got, mediaType)
+	}
+	return body, nil
+}
+
+// publicOHTTPKeyMaterial pairs key configs with their rotation periods. Key
+// configs with an unsupported suite or without a period are skipped.
+func publicOHTTPKeyMaterial(keyConfigs ohttp.KeyConfigs, periods map[byte]ohttpKeyPeriodSpec) (ohttp.KeyConfigs, []gateway.KeyRotationPeriodWithID, error) {
+	kept := make(ohttp.KeyConfigs, 0, len(keyConfigs))
+	rotationPeriods := make([]gateway.KeyRotationPeriodWithID, 0, len(keyConfigs))
+	for _, kc := range keyConfigs {
+		if ohttpSuiteRank(kc) == len(supportedOHTTPSuites) {
+			fmt.Fprintf(os.Stderr, "Skipping OHTTP key_id %d: unsupported suite (KEM 0x%04x)\n", kc.KeyID, uint16(kc.KemID))
+			continue
+		}
+		period, ok := periods[kc.KeyID]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Skipping OHTTP key_id %d: no rotation period\n", kc.KeyID)
+			continue
+		}
+		activeFrom, _ := time.Parse(time.RFC3339, period.ActiveFrom)
+		activeUntil, _ := time.Parse(time.RFC3339, period.ActiveUntil)
+		kept = append(kept, kc)
+		rotationPeriods = append(rotationPeriods, gateway.KeyRotationPeriodWithID{
+			Period: keyrotation.Period{
+				ActiveFrom:  activeFrom,
+				ActiveUntil: activeUntil,
+			},
+			KeyID: kc.KeyID,
+		})
+	}
+	if len(kept) == 0 {
+		return nil, nil, errors.New("no OHTTP key config has a supported suite and a rotation period")
+	}
+	return kept, rotationPeriods, nil
+}
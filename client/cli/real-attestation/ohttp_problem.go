@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/openpcc/ohttp"
+	"github.com/openpcc/openpcc"
+	"github.com/openpcc/openpcc/gateway"
+)
+
+// ohttpKeyProblemType is the RFC 9458 problem type a gateway returns when it
+// cannot use the key configuration a request was encapsulated to.
+const ohttpKeyProblemType = "https://iana.org/assignments/http-problem-types#ohttp-key"
+
+const maxProblemBodyBytes = 64 << 10
+
+// keyProblemFlagKey is the context key of the flag a keyProblemDetector sets
+// for the request that received the oHTTP key problem.
+type keyProblemFlagKey struct{}
+
+// withKeyProblemFlag returns ctx with a fresh flag that is set when a relay
+// request made with ctx gets the oHTTP key problem. Batch, serve and bench run
+// requests concurrently, so each request has its own flag.
+func withKeyProblemFlag(ctx context.Context) (context.Context, *atomic.Bool) {
+	flag := &atomic.Bool{}
+	return context.WithValue(ctx, keyProblemFlagKey{}, flag), flag
+}
+
+// keyProblemDetector wraps the relay transport and flags requests the
+// gateway answered with the oHTTP key problem. The ohttp transport only
+// reports the status code, so this is how the CLI tells a stale key apart
+// from other failures.
+type keyProblemDetector struct {
+	base http.RoundTripper
+}
+
+func (d *keyProblemDetector) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := d.base.RoundTrip(req)
+	flag, tracked := req.Context().Value(keyProblemFlagKey{}).(*atomic.Bool)
+	if err != nil || !tracked || resp.StatusCode != http.StatusBadRequest {
+		return resp, err
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "application/problem+json" {
+		return resp, nil
+	}
+
+	body, readErr := io.ReadAll(io.LimitReader(resp.Body, maxProblemBodyBytes))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if readErr != nil {
+		return resp, nil
+	}
+	var problem struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(body, &problem) == nil && problem.Type == ohttpKeyProblemType {
+		flag.Store(true)
+	}
+	return resp, nil
+}
+
+// withKeyProblemDetector wraps client's transport with a keyProblemDetector.
+func withKeyProblemDetector(client *http.Client) {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Transport = &keyProblemDetector{base: base}
+}
+
+// refreshingClient is an openpcc client that, when the gateway rejects its
+// oHTTP key, reloads key material from the configured sources, skips the
+// rejected key, rebuilds the client and retries the request once.
+type refreshingClient struct {
+	build  func(ohttp.KeyConfigs, []gateway.KeyRotationPeriodWithID) (*openpcc.Client, error)
+	reload func(rejected []string) (ohttp.KeyConfigs, []gateway.KeyRotationPeriodWithID, error)
+
+	mu       sync.Mutex
+	client   *openpcc.Client
+	key      *ohttp.KeyConfig
+	rejected []string
+}
+
+// newRefreshingClient builds the initial client. keyConfigs is empty when
+// oHTTP is disabled, in which case requests are never retried.
+func newRefreshingClient(
+	build func(ohttp.KeyConfigs, []gateway.KeyRotationPeriodWithID) (*openpcc.Client, error),
+	reload func(rejected []string) (ohttp.KeyConfigs, []gateway.KeyRotationPeriodWithID, error),
+	keyConfigs ohttp.KeyConfigs,
+	rotationPeriods []gateway.KeyRotationPeriodWithID,
+) (*refreshingClient, error) {
+	client, err := build(keyConfigs, rotationPeriods)
+	if err != nil {
+		return nil, err
+	}
+	c := &refreshingClient{build: build, reload: reload, client: client}
+	if len(keyConfigs) > 0 {
+		c.key = &keyConfigs[0]
+	}
+	return c, nil
+}
+
+func (c *refreshingClient) current() (*openpcc.Client, *ohttp.KeyConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.client, c.key
+}
+
+func (c *refreshingClient) RoundTrip(req *http.Request) (*http.Response, error) {
+	client, key := c.current()
+	ctx, keyProblem := withKeyProblemFlag(req.Context())
+	resp, err := client.RoundTrip(req.WithContext(ctx))
+	if err == nil || key == nil || !keyProblem.Load() {
+		return resp, err
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return nil, fmt.Errorf("gateway rejected OHTTP key_id %d (%s): %w", key.KeyID, ohttpKeyProblemType, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Gateway rejected OHTTP key_id %d (%s); refreshing key material and retrying once\n", key.KeyID, ohttpKeyProblemType)
+	if refreshErr := c.refresh(client, *key); refreshErr != nil {
+		return nil, fmt.Errorf("gateway rejected OHTTP key_id %d and refreshing key material failed: %v (request error: %w)", key.KeyID, refreshErr, err)
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	client, _ = c.current()
+	return client.RoundTrip(retry)
+}
+
+func (c *refreshingClient) refresh(stale *openpcc.Client, rejected ohttp.KeyConfig) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != stale {
+		// Another request already refreshed the client.
+		return nil
+	}
+
+	fingerprint, err := ohttpKeyFingerprint(rejected)
+	if err != nil {
+		return err
+	}
+	c.rejected = append(c.rejected, fingerprint)
+	keyConfigs, rotationPeriods, err := c.reload(c.rejected)
+	if err != nil {
+		return err
+	}
+	client, err := c.build(keyConfigs, rotationPeriods)
+	if err != nil {
+		return err
+	}
+	if err := c.client.Close(context.Background()); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to close previous OpenPCC client: %v\n", err)
+	}
+	c.client = client
+	c.key = &keyConfigs[0]
+	return nil
+}
+
+// keyID returns the oHTTP key ID the current client encapsulates to. It
+// reports false when oHTTP is disabled.
+func (c *refreshingClient) keyID() (byte, bool) {
+	_, key := c.current()
+	if key == nil {
+		return 0, false
+	}
+	return key.KeyID, true
+}
+
+func (c *refreshingClient) Close(ctx context.Context) error {
+	client, _ := c.current()
+	return client.Close(ctx)
+}
+
+func (c *refreshingClient) CachedVerifiedNodes() ([]openpcc.VerifiedNode, error) {
+	client, _ := c.current()
+	return client.CachedVerifiedNodes()
+}
+
+// dropRejectedOHTTPKeys removes key configs whose fingerprints the gateway
+// has already rejected during this run.
+func dropRejectedOHTTPKeys(rejected []string, keyConfigs ohttp.KeyConfigs, rotationPeriods []gateway.KeyRotationPeriodWithID) (ohttp.KeyConfigs, []gateway.KeyRotationPeriodWithID, error) {
+	if len(rejected) == 0 {
+		return keyConfigs, rotationPeriods, nil
+	}
+	var rejectedIDs []byte
+	kept := make(ohttp.KeyConfigs, 0, len(keyConfigs))
+	for _, kc := range keyConfigs {
+		fingerprint, err := ohttpKeyFingerprint(kc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to fingerprint key_id %d: %w", kc.KeyID, err)
+		}
+		if slices.Contains(rejected, fingerprint) {
+			rejectedIDs = append(rejectedIDs, kc.KeyID)
+			continue
+		}
+		kept = append(kept, kc)
+	}
+	periods := slices.DeleteFunc(slices.Clone(rotationPeriods), func(p gateway.KeyRotationPeriodWithID) bool {
+		return slices.Contains(rejectedIDs, p.KeyID)
+	})
+	if len(kept) == 0 {
+		return nil, nil, errors.New("no OHTTP key left that the gateway has not rejected")
+	}
+	return kept, periods, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/circl/hpke"
+	"github.com/openpcc/ohttp"
+	"github.com/openpcc/openpcc/gateway"
+)
+
+// ohttpSuite is a named KEM/KDF/AEAD combination. The names match the
+// gateway's seedSpec.suite values.
+type ohttpSuite struct {
+	Name string
+	KEM  hpke.KEM
+	KDF  hpke.KDF
+	AEAD hpke.AEAD
+}
+
+const defaultOHTTPSuiteName = "x25519-kyber768-draft00"
+
+// supportedOHTTPSuites lists the suites this client can encapsulate to,
+// strongest first.
+var supportedOHTTPSuites = []ohttpSuite{
+	{Name: "x25519-mlkem768", KEM: hpke.KEM_XWING, KDF: hpke.KDF_HKDF_SHA256, AEAD: hpke.AEAD_AES256GCM},
+	{Name: defaultOHTTPSuiteName, KEM: hpke.KEM_X25519_KYBER768_DRAFT00, KDF: hpke.KDF_HKDF_SHA256, AEAD: hpke.AEAD_AES128GCM},
+	{Name: "x25519", KEM: hpke.KEM_X25519_HKDF_SHA256, KDF: hpke.KDF_HKDF_SHA256, AEAD: hpke.AEAD_AES128GCM},
+}
+
+func lookupOHTTPSuite(name string) (ohttpSuite, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = defaultOHTTPSuiteName
+	}
+	idx := slices.IndexFunc(supportedOHTTPSuites, func(s ohttpSuite) bool { return s.Name == name })
+	if idx == -1 {
+		return ohttpSuite{}, false
+	}
+	return supportedOHTTPSuites[idx], true
+}
+
+// ohttpSuiteRank orders key configs by suite strength; lower is stronger.
+func ohttpSuiteRank(kc ohttp.KeyConfig) int {
+	for rank, suite := range supportedOHTTPSuites {
+		if kc.KemID != suite.KEM {
+			continue
+		}
+		if slices.ContainsFunc(kc.SymmetricAlgorithms, func(alg ohttp.SymmetricAlgorithm) bool {
+			return alg.KDFID == suite.KDF && alg.AEADID == suite.AEAD
+		}) {
+			return rank
+		}
+	}
+	return len(supportedOHTTPSuites)
+}
+
+// selectStrongestOHTTPKey narrows the key material to the active key with the
+// strongest supported suite, preferring the most recently activated key within
+// a suite. openpcc only orders keys by activation time, so it is handed the
+// selected key alone.
+func selectStrongestOHTTPKey(keyConfigs ohttp.KeyConfigs, rotationPeriods []gateway.KeyRotationPeriodWithID, now time.Time) (ohttp.KeyConfigs, []gateway.KeyRotationPeriodWithID, error) {
+	bestIdx := -1
+	var bestConfig ohttp.KeyConfig
+	for idx, period := range rotationPeriods {
+		if !now.After(period.ActiveFrom) || now.After(period.ActiveUntil) {
+			continue
+		}
+		configIdx := slices.IndexFunc(keyConfigs, func(kc ohttp.KeyConfig) bool { return kc.KeyID == period.KeyID })
+		if configIdx == -1 {
+			continue
+		}
+		config := keyConfigs[configIdx]
+		if ohttpSuiteRank(config) == len(supportedOHTTPSuites) {
+			continue
+		}
+		if bestIdx != -1 {
+			rank, bestRank := ohttpSuiteRank(config), ohttpSuiteRank(bestConfig)
+			if rank > bestRank || (rank == bestRank && !period.ActiveFrom.After(rotationPeriods[bestIdx].ActiveFrom)) {
+				continue
+			}
+		}
+		bestIdx = idx
+		bestConfig = config
+	}
+	if bestIdx == -1 {
+		return nil, nil, fmt.Errorf("no active OHTTP key uses a supported suite")
+	}
+	return ohttp.KeyConfigs{bestConfig}, []gateway.KeyRotationPeriodWithID{rotationPeriods[bestIdx]}, nil
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// openAISampling holds the OpenAI sampling parameters that have an Ollama
+// option.
+type openAISampling struct {
+	Temperature         *float64        `json:"temperature"`
+	TopP                *float64        `json:"top_p"`
+	MaxTokens           *int            `json:"max_tokens"`
+	MaxCompletionTokens *int            `json:"max_completion_tokens"`
+	Stop                json.RawMessage `json:"stop"`
+	Seed                *int            `json:"seed"`
+	PresencePenalty     *float64        `json:"presence_penalty"`
+	FrequencyPenalty    *float64        `json:"frequency_penalty"`
+	StreamOptions       *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+}
+
+func (s openAISampling) ollamaOptions() (map[string]any, error) {
+	options := map[string]any{}
+	if s.Temperature != nil {
+		options["temperature"] = *s.Temperature
+	}
+	if s.TopP != nil {
+		options["top_p"] = *s.TopP
+	}
+	if s.MaxCompletionTokens != nil {
+		options["num_predict"] = *s.MaxCompletionTokens
+	} else if s.MaxTokens != nil {
+		options["num_predict"] = *s.MaxTokens
+	}
+	if s.Seed != nil {
+		options["seed"] = *s.Seed
+	}
+	if s.PresencePenalty != nil {
+		options["presence_penalty"] = *s.PresencePenalty
+	}
+	if s.FrequencyPenalty != nil {
+		options["frequency_penalty"] = *s.FrequencyPenalty
+	}
+	if len(s.Stop) > 0 && string(s.Stop) != "null" {
+		var stop []string
+		if err := json.Unmarshal(s.Stop, &stop); err != nil {
+			var single string
+			if err := json.Unmarshal(s.Stop, &single); err != nil {
+				return nil, errors.New("stop must be a string or an array of strings")
+			}
+			stop = []string{single}
+		}
+		options["stop"] = stop
+	}
+	return options, nil
+}
+
+func (s openAISampling) includeUsage() bool {
+	return s.StreamOptions != nil && s.StreamOptions.IncludeUsage
+}
+
+type openAIChatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+type openAIChatRequest struct {
+	Model    string              `json:"model"`
+	Messages []openAIChatMessage `json:"messages"`
+	Stream   bool                `json:"stream"`
+	openAISampling
+}
+
+type openAICompletionRequest struct {
+	Model  string          `json:"model"`
+	Prompt json.RawMessage `json:"prompt"`
+	Stream bool            `json:"stream"`
+	openAISampling
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func openAIUsageFrom(final generateChunk) *openAIUsage {
+	return &openAIUsage{
+		PromptTokens:     final.PromptEvalCount,
+		CompletionTokens: final.EvalCount,
+		TotalTokens:      final.PromptEvalCount + final.EvalCount,
+	}
+}
+
+// openAIDelta is the message fragment of a streamed chat chunk.
+type openAIDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type openAIChoice struct {
+	Index        int          `json:"index"`
+	Message      *chatMessage `json:"message,omitempty"`
+	Delta        *openAIDelta `json:"delta,omitempty"`
+	Text         *string      `json:"text,omitempty"`
+	Logprobs     *struct{}    `json:"logprobs"`
+	FinishReason *string      `json:"finish_reason"`
+}
+
+type openAIResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   *openAIUsage   `json:"usage,omitempty"`
+}
+
+// openAIFinishReason maps an Ollama done_reason.
+func openAIFinishReason(doneReason string) *string {
+	reason := "stop"
+	if doneReason == "length" {
+		reason = "length"
+	}
+	return &reason
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, message string) {
+	errType := "api_error"
+	if status >= 400 && status < 500 {
+		errType = "invalid_request_error"
+	}
+	writeProxyJSON(w, status, map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    errType,
+			"param":   nil,
+			"code":    nil,
+		},
+	})
+}
+
+// openAIMessageText flattens string content or an array of text parts.
+func openAIMessageText(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", errors.New("content must be a string or an array of content parts")
+	}
+	var builder strings.Builder
+	for _, part := range parts {
+		if part.Type != "text" {
+			return "", fmt.Errorf("content part type %q is not supported", part.Type)
+		}
+		builder.WriteString(part.Text)
+	}
+	return builder.String(), nil
+}
+
+func (p *proxyServer) openAIModels(w http.ResponseWriter, r *http.Request) {
+	models, err := p.listModels(r.Context())
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	data := []map[string]any{}
+	for _, model := range models {
+		data = append(data, map[string]any{
+			"id":       model,
+			"object":   "model",
+			"created":  p.started.Unix(),
+			"owned_by": "openpcc",
+		})
+	}
+	writeProxyJSON(w, http.StatusOK, map[string]any{"object": "list", "data": data})
+}
+
+func (p *proxyServer) openAIChatCompletions(w http.ResponseWriter, r *http.Request) {
+	var req openAIChatRequest
+	if err := decodeProxyRequest(w, r, &req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Messages) == 0 {
+		writeOpenAIError(w, http.StatusBadRequest, "messages must not be empty")
+		return
+	}
+	messages := make([]chatMessage, 0, len(req.Messages))
+	for idx, message := range req.Messages {
+		text, err := openAIMessageText(message.Content)
+		if err != nil {
+			writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("messages[%d]: %v", idx, err))
+			return
+		}
+		role := message.Role
+		if role == "developer" {
+			role = "system"
+		}
+		messages = append(messages, chatMessage{Role: role, Content: text})
+	}
+	options, err := req.ollamaOptions()
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	model := firstNonEmpty(req.Model, p.model)
+	body := map[string]any{"model": model, "messages": messages, "stream": req.Stream}
+	if len(options) > 0 {
+		body["options"] = options
+	}
+	p.proxyOpenAI(w, r, "/api/chat", model, body, "chatcmpl-", "chat.completion", req.Stream, req.includeUsage())
+}
+
+func (p *proxyServer) openAICompletions(w http.ResponseWriter, r *http.Request) {
+	var req openAICompletionRequest
+	if err := decodeProxyRequest(w, r, &req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var prompt string
+	if err := json.Unmarshal(req.Prompt, &prompt); err != nil {
+		var prompts []string
+		if err := json.Unmarshal(req.Prompt, &prompts); err != nil || len(prompts) != 1 {
+			writeOpenAIError(w, http.StatusBadRequest, "prompt must be a string or an array with one string")
+			return
+		}
+		prompt = prompts[0]
+	}
+	options, err := req.ollamaOptions()
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	model := firstNonEmpty(req.Model, p.model)
+	body := map[string]any{"model": model, "prompt": prompt, "stream": req.Stream}
+	if len(options) > 0 {
+		body["options"] = options
+	}
+	p.proxyOpenAI(w, r, "/api/generate", model, body, "cmpl-", "text_completion", req.Stream, req.includeUsage())
+}
+
+// proxyOpenAI sends body upstream and answers with an OpenAI response of
+// the given object type: a single JSON object, or SSE chunks when stream is
+// set.
+func (p *proxyServer) proxyOpenAI(w http.ResponseWriter, r *http.Request, path, model string, body any, idPrefix, object string, stream, includeUsage bool) {
+	resp, err := p.upstream(r.Context(), path, model, body)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		writeOpenAIError(w, resp.StatusCode, upstreamError(resp))
+		return
+	}
+
+	chat := object == "chat.completion"
+	choice := func(text string, finishReason *string) openAIChoice {
+		if chat && stream {
+			return openAIChoice{Delta: &openAIDelta{Content: text}, FinishReason: finishReason}
+		}
+		if chat {
+			return openAIChoice{Message: &chatMessage{Role: "assistant", Content: text}, FinishReason: finishReason}
+		}
+		return openAIChoice{Text: &text, FinishReason: finishReason}
+	}
+	out := openAIResponse{ID: newProxyID(idPrefix), Object: object, Created: time.Now().Unix(), Model: model}
+
+	if !stream {
+		var final generateChunk
+		if err := json.NewDecoder(resp.Body).Decode(&final); err != nil {
+			writeOpenAIError(w, http.StatusBadGateway, fmt.Sprintf("invalid upstream response: %v", err))
+			return
+		}
+		if final.Error != "" {
+			writeOpenAIError(w, http.StatusBadGateway, final.Error)
+			return
+		}
+		out.Choices = []openAIChoice{choice(final.text(), openAIFinishReason(final.DoneReason))}
+		out.Usage = openAIUsageFrom(final)
+		writeProxyJSON(w, http.StatusOK, out)
+		return
+	}
+
+	if chat {
+		out.Object = "chat.completion.chunk"
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher := http.NewResponseController(w)
+	send := func(event any) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return err
+		}
+		return flusher.Flush()
+	}
+
+	if chat {
+		first := out
+		first.Choices = []openAIChoice{{Delta: &openAIDelta{Role: "assistant"}}}
+		if err := send(first); err != nil {
+			return
+		}
+	}
+	final, err := decodeStream(resp.Body, func(chunk generateChunk) error {
+		if chunk.Done || chunk.text() == "" {
+			return nil
+		}
+		event := out
+		event.Choices = []openAIChoice{choice(chunk.text(), nil)}
+		return send(event)
+	})
+	if err != nil {
+		_ = send(map[string]any{"error": map[string]any{"message": err.Error(), "type": "api_error"}})
+		return
+	}
+	last := out
+	last.Choices = []openAIChoice{choice(final.text(), openAIFinishReason(final.DoneReason))}
+	if err := send(last); err != nil {
+		return
+	}
+	if includeUsage {
+		usage := out
+		usage.Choices = []openAIChoice{}
+		usage.Usage = openAIUsageFrom(final)
+		if err := send(usage); err != nil {
+			return
+		}
+	}
+	_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	_ = flusher.Flush()
+}
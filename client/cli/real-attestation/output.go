@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Output formats of the single-prompt run.
+const (
+	outputText  = "text"
+	outputJSON  = "json"
+	outputJSONL = "jsonl"
+	outputRaw   = "raw"
+)
+
+// parseOutputFormat reads -output. The default keeps the earlier behaviour:
+// the raw body, or the text as it arrives with -stream.
+func parseOutputFormat(args []string, stream bool) (string, error) {
+	value, found, err := findStringFlag(args, "output")
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		if stream {
+			return outputText, nil
+		}
+		return outputRaw, nil
+	}
+	switch format := strings.ToLower(strings.TrimSpace(value)); format {
+	case outputText, outputJSON, outputJSONL, outputRaw:
+		return format, nil
+	default:
+		return "", fmt.Errorf("invalid -output value %q (use text, json, jsonl or raw)", value)
+	}
+}
+
+// responseMeta is what the CLI knows about a request besides its response.
+type responseMeta struct {
+	Model       string
+	NodeTags    []string
+	OHTTP       bool
+	Attestation string
+	PolicyID    string
+}
+
+type responseTimings struct {
+	LatencyMS    int64   `json:"latency_ms"`
+	TotalMS      float64 `json:"total_ms"`
+	LoadMS       float64 `json:"load_ms"`
+	PromptEvalMS float64 `json:"prompt_eval_ms"`
+	EvalMS       float64 `json:"eval_ms"`
+}
+
+type responseUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	Tokens       int `json:"tokens"`
+}
+
+// responseEnvelope is the -output json/jsonl form of a response. Body is the
+// upstream JSON body, or the done chunk with -stream.
+type responseEnvelope struct {
+	Model               string          `json:"model"`
+	NodeTags            []string        `json:"node_tags"`
+	OHTTP               bool            `json:"ohttp"`
+	OHTTPKeyID          *byte           `json:"ohttp_key_id,omitempty"`
+	Attestation         string          `json:"attestation"`
+	AttestationPolicyID string          `json:"attestation_policy_id,omitempty"`
+	Status              int             `json:"status"`
+	Timings             responseTimings `json:"timings"`
+	Usage               responseUsage   `json:"usage"`
+	Text                string          `json:"text"`
+	Body                json.RawMessage `json:"body,omitempty"`
+	Error               string          `json:"error,omitempty"`
+}
+
+func newResponseEnvelope(meta responseMeta, client *refreshingClient, status int, latency time.Duration) responseEnvelope {
+	envelope := responseEnvelope{
+		Model:               meta.Model,
+		NodeTags:            meta.NodeTags,
+		OHTTP:               meta.OHTTP,
+		Attestation:         meta.Attestation,
+		AttestationPolicyID: meta.PolicyID,
+		Status:              status,
+		Timings:             responseTimings{LatencyMS: latency.Milliseconds()},
+	}
+	// Read after the request, since a key problem retry may switch keys.
+	if keyID, ok := client.keyID(); ok {
+		envelope.OHTTPKeyID = &keyID
+	}
+	return envelope
+}
+
+func (e *responseEnvelope) setChunk(chunk generateChunk) {
+	ms := func(ns int64) float64 { return float64(ns) / float64(time.Millisecond) }
+	e.Timings.TotalMS = ms(chunk.TotalDuration)
+	e.Timings.LoadMS = ms(chunk.LoadDuration)
+	e.Timings.PromptEvalMS = ms(chunk.PromptEvalDuration)
+	e.Timings.EvalMS = ms(chunk.EvalDuration)
+	e.Usage = responseUsage{PromptTokens: chunk.PromptEvalCount, Tokens: chunk.EvalCount}
+}
+
+// setBody stores body as JSON, or as a JSON string when it is not JSON.
+func (e *responseEnvelope) setBody(body []byte) {
+	body = []byte(strings.TrimSpace(string(body)))
+	if json.Valid(body) {
+		e.Body = body
+		return
+	}
+	e.Body, _ = json.Marshal(string(body))
+}
+
+func writeResponseEnvelope(w io.Writer, format string, envelope responseEnvelope) error {
+	var data []byte
+	var err error
+	if format == outputJSON {
+		data, err = json.MarshalIndent(envelope, "", "  ")
+	} else {
+		data, err = json.Marshal(envelope)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// writeResponse prints resp in the given format and returns an error for a
+// non-2xx status or a failed stream, after printing what there is.
+func writeResponse(w io.Writer, resp *http.Response, format string, stream bool, meta responseMeta, client *refreshingClient, started time.Time) error {
+	envelopeOut := format == outputJSON || format == outputJSONL
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read response: %w", err)
+		}
+		if envelopeOut {
+			envelope := newResponseEnvelope(meta, client, resp.StatusCode, time.Since(started))
+			envelope.setBody(body)
+			envelope.Error = "non-OK response: " + resp.Status
+			if err := writeResponseEnvelope(w, format, envelope); err != nil {
+				return err
+			}
+		}
+		return fmt.Errorf("non-OK response: %s\n%s", resp.Status, string(body))
+	}
+
+	if stream {
+		var final generateChunk
+		var text strings.Builder
+		var err error
+		switch format {
+		case outputText:
+			final, err = streamGenerate(resp.Body, w)
+			fmt.Fprintln(w)
+		case outputRaw:
+			final, err = decodeStream(io.TeeReader(resp.Body, w), func(generateChunk) error { return nil })
+		default:
+			final, err = streamGenerate(resp.Body, &text)
+		}
+		if envelopeOut {
+			envelope := newResponseEnvelope(meta, client, resp.StatusCode, time.Since(started))
+			envelope.Text = text.String()
+			if err != nil {
+				envelope.Error = "stream failed: " + err.Error()
+			} else {
+				envelope.setChunk(final)
+				envelope.Body, _ = json.Marshal(final)
+			}
+			if err := writeResponseEnvelope(w, format, envelope); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("stream failed: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Generation finished: %s\n", final.stats())
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	var chunk generateChunk
+	parsed := json.Unmarshal(body, &chunk) == nil
+	switch format {
+	case outputRaw:
+		_, err = fmt.Fprintln(w, string(body))
+	case outputText:
+		if !parsed {
+			_, err = fmt.Fprintln(w, string(body))
+		} else {
+			_, err = fmt.Fprintln(w, chunk.text())
+		}
+	default:
+		envelope := newResponseEnvelope(meta, client, resp.StatusCode, time.Since(started))
+		envelope.setBody(body)
+		if parsed {
+			envelope.Text = chunk.text()
+			envelope.setChunk(chunk)
+		}
+		err = writeResponseEnvelope(w, format, envelope)
+	}
+	return err
+}
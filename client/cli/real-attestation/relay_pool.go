@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Relay selection strategies.
+const (
+	relayStrategyRandom  = "random"
+	relayStrategyLatency = "latency"
+)
+
+const (
+	relayMinBackoff = 10 * time.Second
+	relayMaxBackoff = 5 * time.Minute
+	// relayLatencyWeight is the weight of a new connect time in a relay's
+	// moving average.
+	relayLatencyWeight = 0.3
+)
+
+func parseRelayStrategy(raw string) (string, error) {
+	switch strategy := strings.ToLower(strings.TrimSpace(raw)); strategy {
+	case "":
+		return relayStrategyRandom, nil
+	case relayStrategyRandom, relayStrategyLatency:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("invalid relay strategy %q (use random or latency)", raw)
+	}
+}
+
+// splitRelayURLs parses a comma-separated relay list, dropping duplicates.
+func splitRelayURLs(raw string) []string {
+	var urls []string
+	for _, value := range strings.Split(raw, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		value = normalizeURL(value)
+		if !slices.Contains(urls, value) {
+			urls = append(urls, value)
+		}
+	}
+	return urls
+}
+
+type relayState struct {
+	url        *url.URL
+	failures   int
+	avoidUntil time.Time
+	// latency is the moving average of TCP connect times, zero until a
+	// new connection to the relay has been made.
+	latency time.Duration
+}
+
+// relayPool spreads oHTTP requests over several relays. The ohttp transport
+// is built with one relay URL, entry; the pool sends each request to that
+// URL to a relay it picks instead. A relay that fails is avoided for a
+// backoff that doubles with each consecutive failure. A request that could
+// not connect, before any of its body was sent, is retried on another relay.
+type relayPool struct {
+	base     http.RoundTripper
+	entry    string
+	strategy string
+
+	mu     sync.Mutex
+	relays []*relayState
+}
+
+// withRelayPool routes client's requests to relayURLs[0] through a relay
+// pool over all of relayURLs.
+func withRelayPool(client *http.Client, relayURLs []string, strategy string) (*relayPool, error) {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	pool := &relayPool{base: base, strategy: strategy}
+	for _, raw := range relayURLs {
+		parsed, err := url.Parse(raw)
+		if err != nil || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid relay URL %q", raw)
+		}
+		pool.relays = append(pool.relays, &relayState{url: parsed})
+	}
+	// The ohttp transport sends to the parsed form of the URL.
+	pool.entry = pool.relays[0].url.String()
+	client.Transport = pool
+	return pool, nil
+}
+
+func (p *relayPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(p.relays) < 2 || req.URL.String() != p.entry {
+		return p.base.RoundTrip(req)
+	}
+
+	var body *retryableBody
+	if req.Body != nil {
+		body = &retryableBody{rc: req.Body}
+		defer body.release()
+	}
+	tried := map[int]bool{}
+	var lastErr error
+	for len(tried) < len(p.relays) {
+		idx := p.pick(tried)
+		tried[idx] = true
+
+		relay := p.relays[idx]
+		out := req.Clone(p.traceConnect(req, idx))
+		out.URL = relay.url
+		out.Host = ""
+		if body != nil {
+			out.Body = body
+		}
+		resp, err := p.base.RoundTrip(out)
+		if err == nil {
+			switch resp.StatusCode {
+			case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+				p.markFailed(idx)
+			default:
+				p.markOK(idx)
+			}
+			return resp, nil
+		}
+		lastErr = err
+		p.markFailed(idx)
+		if !isConnectError(err) || (body != nil && body.started()) || len(tried) == len(p.relays) {
+			break
+		}
+		fmt.Fprintf(os.Stderr, "Relay %s failed (%v); trying another relay\n", relay.url, err)
+	}
+	return nil, lastErr
+}
+
+// isConnectError reports whether err means the relay could not be reached,
+// as opposed to a failure after the request was sent.
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "proxyconnect")
+}
+
+// pick returns the index of the relay to try next, among the untried ones.
+// Relays being avoided are only used when no other relay is left.
+func (p *relayPool) pick(tried map[int]bool) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var healthy, avoided []int
+	for idx, relay := range p.relays {
+		if tried[idx] {
+			continue
+		}
+		if relay.avoidUntil.After(now) {
+			avoided = append(avoided, idx)
+		} else {
+			healthy = append(healthy, idx)
+		}
+	}
+	if len(healthy) == 0 {
+		// The relay whose backoff ends first is the most likely to work.
+		return slices.MinFunc(avoided, func(a, b int) int {
+			return p.relays[a].avoidUntil.Compare(p.relays[b].avoidUntil)
+		})
+	}
+	if p.strategy == relayStrategyLatency {
+		// Unmeasured relays go first, so every relay gets a measurement.
+		var unmeasured []int
+		for _, idx := range healthy {
+			if p.relays[idx].latency == 0 {
+				unmeasured = append(unmeasured, idx)
+			}
+		}
+		if len(unmeasured) == 0 {
+			return slices.MinFunc(healthy, func(a, b int) int {
+				return int(p.relays[a].latency - p.relays[b].latency)
+			})
+		}
+		healthy = unmeasured
+	}
+	return healthy[rand.IntN(len(healthy))]
+}
+
+// traceConnect records the TCP connect time of new connections to relay idx.
+func (p *relayPool) traceConnect(req *http.Request, idx int) context.Context {
+	var started time.Time
+	return httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		ConnectStart: func(string, string) { started = time.Now() },
+		ConnectDone: func(_, _ string, err error) {
+			if err != nil || started.IsZero() {
+				return
+			}
+			elapsed := time.Since(started)
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			relay := p.relays[idx]
+			if relay.latency == 0 {
+				relay.latency = elapsed
+			} else {
+				relay.latency += time.Duration(relayLatencyWeight * float64(elapsed-relay.latency))
+			}
+		},
+	})
+}
+
+func (p *relayPool) markFailed(idx int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	relay := p.relays[idx]
+	relay.failures++
+	backoff := relayMinBackoff << min(relay.failures-1, 10)
+	relay.avoidUntil = time.Now().Add(min(backoff, relayMaxBackoff))
+}
+
+func (p *relayPool) markOK(idx int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	relay := p.relays[idx]
+	relay.failures = 0
+	relay.avoidUntil = time.Time{}
+}
+
+// retryableBody lets a request body be sent again after a failed attempt
+// that did not read any of it. http.Transport closes the body when a round
+// trip fails, so Close is held back until a byte has been read or the pool
+// is done with the request.
+type retryableBody struct {
+	rc io.ReadCloser
+
+	mu             sync.Mutex
+	read           bool
+	closeRequested bool
+	released       bool
+	closed         bool
+}
+
+func (b *retryableBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	if n > 0 {
+		b.mu.Lock()
+		b.read = true
+		b.mu.Unlock()
+	}
+	return n, err
+}
+
+func (b *retryableBody) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.read || b.released {
+		return b.closeLocked()
+	}
+	b.closeRequested = true
+	return nil
+}
+
+func (b *retryableBody) started() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.read
+}
+
+// release ends the retries: a held back Close happens now, and later ones
+// go through.
+func (b *retryableBody) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.released = true
+	if b.closeRequested {
+		_ = b.closeLocked()
+	}
+}
+
+func (b *retryableBody) closeLocked() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	return b.rc.Close()
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultServeListenAddr = "127.0.0.1:8080"
+	maxProxyBodyBytes      = 8 << 20
+	// modelListTTL is how long the model list from the router is reused by
+	// /v1/models and /api/tags.
+	modelListTTL = 30 * time.Second
+)
+
+type serveOptions struct {
+	Listen string
+}
+
+func parseServeOptions(args []string) (serveOptions, error) {
+	opts := serveOptions{Listen: defaultServeListenAddr}
+	if value, found, err := findStringFlag(args, "listen"); err != nil {
+		return opts, err
+	} else if found {
+		if strings.TrimSpace(value) == "" {
+			return opts, errors.New("-listen must not be empty")
+		}
+		opts.Listen = value
+	}
+	return opts, nil
+}
+
+// proxyServer lets local apps that speak the OpenAI or Ollama API use the
+// OpenPCC client. Every call becomes one Ollama-style request sent through
+// client.RoundTrip, with the same attestation and oHTTP setup as the CLI.
+type proxyServer struct {
+	client    *refreshingClient
+	badges    *badgeStore
+	model     string
+	discovery routerDiscovery
+	started   time.Time
+
+	mu       sync.Mutex
+	models   []string
+	modelsAt time.Time
+}
+
+// runServe serves until interrupted.
+func runServe(client *refreshingClient, badges *badgeStore, model string, discovery routerDiscovery, opts serveOptions) error {
+	proxy := &proxyServer{client: client, badges: badges, model: model, discovery: discovery, started: time.Now()}
+	server := &http.Server{
+		Addr:              opts.Listen,
+		Handler:           proxy.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Fprintf(os.Stderr, "Serving the OpenAI API on http://%s/v1 and the Ollama API on http://%s/api (default model %s)\n", opts.Listen, opts.Listen, model)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (p *proxyServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/models", p.openAIModels)
+	mux.HandleFunc("POST /v1/chat/completions", p.openAIChatCompletions)
+	mux.HandleFunc("POST /v1/completions", p.openAICompletions)
+	mux.HandleFunc("GET /{$}", p.ollamaRoot)
+	mux.HandleFunc("GET /api/tags", p.ollamaTags)
+	for _, path := range []string{"/api/generate", "/api/chat", "/api/embed"} {
+		mux.HandleFunc("POST "+path, p.ollamaPassthrough)
+	}
+	return logProxyRequests(mux)
+}
+
+// upstream sends an Ollama request body to path through the OpenPCC client.
+// A model the badge did not cover before is dropped from it again when the
+// request fails, so mistyped names do not pile up in the badge.
+func (p *proxyServer) upstream(ctx context.Context, path, model string, body any) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://confsec.invalid"+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Confsec-Node-Tags", "model="+model)
+
+	added, err := p.badges.add(model)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.RoundTrip(req)
+	if added && (err != nil || resp.StatusCode < 200 || resp.StatusCode >= 300) {
+		if removeErr := p.badges.remove(model); removeErr != nil {
+			fmt.Fprintf(os.Stderr, "Failed to drop model %s from the badge: %v\n", model, removeErr)
+		}
+	}
+	return resp, err
+}
+
+// listModels returns the models served by the nodes registered with the
+// router, refreshed at most every modelListTTL.
+func (p *proxyServer) listModels(ctx context.Context) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.models != nil && time.Since(p.modelsAt) < modelListTTL {
+		return slices.Clone(p.models), nil
+	}
+
+	discovery := p.discovery
+	if _, key := p.client.current(); key != nil {
+		discovery.KeyConfig = *key
+	}
+	ctx, cancel := context.WithTimeout(ctx, modelDiscoveryTimeout)
+	defer cancel()
+	catalog, err := discoverModels(ctx, discovery)
+	if err != nil {
+		return nil, fmt.Errorf("model discovery failed: %w", err)
+	}
+	models := make([]string, 0, len(catalog.Models))
+	for _, summary := range catalog.Models {
+		models = append(models, summary.Model)
+	}
+	p.models, p.modelsAt = models, time.Now()
+	return slices.Clone(models), nil
+}
+
+// upstreamError returns the message of a non-2xx Ollama response.
+func upstreamError(resp *http.Response) string {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxProblemBodyBytes))
+	var ollamaErr struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &ollamaErr) == nil && ollamaErr.Error != "" {
+		return ollamaErr.Error
+	}
+	if text := strings.TrimSpace(string(body)); text != "" {
+		return text
+	}
+	return resp.Status
+}
+
+func decodeProxyRequest(w http.ResponseWriter, r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxProxyBodyBytes))
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+func writeProxyJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func newProxyID(prefix string) string {
+	var buf [12]byte
+	_, _ = rand.Read(buf[:])
+	return prefix + hex.EncodeToString(buf[:])
+}
+
+type proxyStatusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *proxyStatusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController flush streamed responses.
+func (r *proxyStatusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func logProxyRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		recorder := &proxyStatusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		fmt.Fprintf(os.Stderr, "%s %s -> %d (%s)\n", r.Method, r.URL.Path, recorder.status, time.Since(started).Round(time.Millisecond))
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/openpcc/openpcc"
+	"github.com/openpcc/openpcc/attestation/verify"
+	"github.com/openpcc/openpcc/router/api"
+	"github.com/openpcc/openpcc/transparency"
+)
+
+type verifyOptions struct {
+	Tags []string
+}
+
+// parseVerifyOptions reads -tags, a comma-separated list of node tags such
+// as model=llama3.2:1b,engine=ollama. Without it every node is checked.
+func parseVerifyOptions(args []string) (verifyOptions, error) {
+	var opts verifyOptions
+	value, found, err := findStringFlag(args, "tags")
+	if err != nil || !found {
+		return opts, err
+	}
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if key, _, ok := strings.Cut(tag, "="); !ok || key == "" {
+			return opts, fmt.Errorf("invalid -tags entry %q (use key=value)", tag)
+		}
+		opts.Tags = append(opts.Tags, tag)
+	}
+	return opts, nil
+}
+
+type nodeVerification struct {
+	ID         string    `json:"id"`
+	Tags       []string  `json:"tags"`
+	Passed     bool      `json:"passed"`
+	Reason     string    `json:"reason,omitempty"`
+	VerifiedAt time.Time `json:"verified_at"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+type verifyReport struct {
+	Router    string             `json:"router"`
+	OHTTP     bool               `json:"ohttp"`
+	Tags      []string           `json:"tags"`
+	CheckedAt time.Time          `json:"checked_at"`
+	Passed    int                `json:"passed"`
+	Failed    int                `json:"failed"`
+	Nodes     []nodeVerification `json:"nodes"`
+}
+
+// newNodeVerifier returns the verifier the openpcc client would build for
+// cfg: real attestation checked against cfg's identity policy.
+func newNodeVerifier(cfg openpcc.Config, httpClient *http.Client) (verify.Verifier, error) {
+	transparencyVerifier, err := transparency.NewVerifier(cfg.TransparencyVerifier, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sigstore bundle verifier: %w", err)
+	}
+	return verify.NewConfidentSecurityVerifier(transparency.NewCachedVerifier(transparencyVerifier), *cfg.TransparencyIdentityPolicy), nil
+}
+
+// runVerify fetches the evidence of every node matching opts.Tags and
+// verifies each one. No prompt is sent and no credit is spent; only the
+// attestation token of the router query.
+func runVerify(ctx context.Context, discovery routerDiscovery, verifier verify.Verifier, opts verifyOptions) (verifyReport, error) {
+	report := verifyReport{
+		Router:    discovery.target(),
+		OHTTP:     discovery.OHTTP,
+		Tags:      append([]string{}, opts.Tags...),
+		CheckedAt: time.Now().UTC(),
+		Nodes:     []nodeVerification{},
+	}
+	items, err := fetchComputeManifests(ctx, discovery, opts.Tags)
+	if err != nil {
+		return report, err
+	}
+	for _, item := range items {
+		result := nodeVerification{ID: item.GetId(), Tags: slices.Sorted(slices.Values(item.GetTags()))}
+		started := time.Now()
+		var manifest api.ComputeManifest
+		if err := manifest.UnmarshalProto(item); err != nil {
+			result.Reason = fmt.Sprintf("invalid compute manifest: %v", err)
+		} else if _, err := verifier.VerifyComputeNode(ctx, manifest.Evidence); err != nil {
+			result.Reason = err.Error()
+		} else {
+			result.Passed = true
+		}
+		result.VerifiedAt = time.Now().UTC()
+		result.DurationMS = time.Since(started).Milliseconds()
+		if result.Passed {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+		report.Nodes = append(report.Nodes, result)
+	}
+	return report, nil
+}
+
+func writeVerifyTable(w io.Writer, report verifyReport) error {
+	tags := "all nodes"
+	if len(report.Tags) > 0 {
+		tags = strings.Join(report.Tags, ",")
+	}
+	fmt.Fprintf(w, "Router: %s (ohttp=%t), tags: %s\n", report.Router, report.OHTTP, tags)
+	if len(report.Nodes) == 0 {
+		fmt.Fprintln(w, "No nodes matched.")
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tRESULT\tTAGS\tREASON")
+	for _, node := range report.Nodes {
+		result := "PASS"
+		if !node.Passed {
+			result = "FAIL"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", node.ID, result, joinOrDash(node.Tags), firstNonEmpty(node.Reason, "-"))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%d passed, %d failed\n", report.Passed, report.Failed)
+	return err
+}
+
+// writeVerifyReport prints report as a table, or as JSON for -output
+// json/jsonl.
+func writeVerifyReport(w io.Writer, format string, report verifyReport) error {
+	var data []byte
+	var err error
+	switch format {
+	case outputJSON:
+		data, err = json.MarshalIndent(report, "", "  ")
+	case outputJSONL:
+		data, err = json.Marshal(report)
+	default:
+		return writeVerifyTable(w, report)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
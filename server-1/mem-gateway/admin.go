@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const maxAdminBodyBytes = 64 << 10
+
+// newAdminHandler serves the operator endpoints. It listens on
+// GATEWAY_ADMIN_ADDR, which is bound to loopback by default. /faults is only
+// served when faults is non-nil, i.e. fault injection is opted in.
+func newAdminHandler(metrics *metricsRegistry, faults *faultInjector, headers headerAudit, revocations *revocationStore) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics)
+	if faults != nil {
+		mux.Handle("/faults", faults)
+	}
+	mux.Handle("/debug/headers", headers)
+	mux.Handle("/revocations", revocations)
+	return mux
+}
+
+func readAdminBody(r *http.Request) ([]byte, error) {
+	raw, err := io.ReadAll(io.LimitReader(r.Body, maxAdminBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > maxAdminBodyBytes {
+		return nil, fmt.Errorf("request body exceeds %d bytes", maxAdminBodyBytes)
+	}
+	return raw, nil
+}
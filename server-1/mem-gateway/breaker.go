@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxReplayBodyBytes bounds how much of a request body is buffered so that a
+// failed dial to the router can be retried.
+const maxReplayBodyBytes = 64 << 10
+
+type breakerSettings struct {
+	// FailureThreshold is the number of consecutive router failures that opens
+	// the circuit. Zero disables the breaker.
+	FailureThreshold int
+	// Cooldown is how long the circuit stays open before probing.
+	Cooldown time.Duration
+	// HalfOpenProbes is the number of concurrent probe requests allowed while
+	// half-open.
+	HalfOpenProbes int
+	// Retries is the number of extra attempts after a failed dial.
+	Retries int
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type breakerOutcome int
+
+const (
+	outcomeSuccess breakerOutcome = iota
+	outcomeFailure
+	outcomeIgnored
+)
+
+// circuitOpenError is returned by the breaker transport instead of contacting
+// the router while the circuit is open.
+type circuitOpenError struct {
+	router     string
+	retryAfter time.Duration
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("circuit to router %s is open", e.router)
+}
+
+func (e *circuitOpenError) retryAfterSeconds() string {
+	return strconv.Itoa(max(1, int(math.Ceil(e.retryAfter.Seconds()))))
+}
+
+// circuitBreaker tracks consecutive failures toward one router URL.
+type circuitBreaker struct {
+	router   string
+	settings breakerSettings
+	metrics  *metricsRegistry
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probes   int
+	// halfOpens numbers the half-open periods, so that results of probes
+	// sent in an earlier one are not mistaken for current probes.
+	halfOpens uint64
+}
+
+func newCircuitBreaker(router string, settings breakerSettings, metrics *metricsRegistry) *circuitBreaker {
+	if settings.FailureThreshold <= 0 {
+		return nil
+	}
+	if settings.HalfOpenProbes <= 0 {
+		settings.HalfOpenProbes = 1
+	}
+	b := &circuitBreaker{router: router, settings: settings, metrics: metrics}
+	metrics.set("gateway_router_circuit_state", float64(breakerClosed), "router", router)
+	return b
+}
+
+// allow reports whether a request may be sent to the router. A request sent
+// while half-open is a probe and gets the half-open period it probes, which is
+// never zero; other requests get zero.
+func (b *circuitBreaker) allow() (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		remaining := b.settings.Cooldown - time.Since(b.openedAt)
+		if remaining > 0 {
+			return 0, &circuitOpenError{router: b.router, retryAfter: remaining}
+		}
+		b.halfOpens++
+		b.probes = 0
+		b.setState(breakerHalfOpen)
+		fallthrough
+	case breakerHalfOpen:
+		if b.probes >= b.settings.HalfOpenProbes {
+			return 0, &circuitOpenError{router: b.router, retryAfter: b.settings.Cooldown}
+		}
+		b.probes++
+		return b.halfOpens, nil
+	default:
+		return 0, nil
+	}
+}
+
+// record applies the outcome of a request allowed with probe. Once the
+// circuit has opened, only probes of the current half-open period change its
+// state; requests that were already in flight cannot close or reopen it.
+func (b *circuitBreaker) record(probe uint64, outcome breakerOutcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current := probe != 0 && probe == b.halfOpens && b.state == breakerHalfOpen
+	if current {
+		b.probes--
+	}
+	switch outcome {
+	case outcomeSuccess:
+		if b.state == breakerClosed {
+			b.failures = 0
+		} else if current {
+			b.failures = 0
+			b.setState(breakerClosed)
+		}
+	case outcomeFailure:
+		b.failures++
+		b.metrics.inc("gateway_router_failures_total", "router", b.router)
+		if current || (b.state == breakerClosed && b.failures >= b.settings.FailureThreshold) {
+			b.openedAt = time.Now()
+			b.setState(breakerOpen)
+		}
+	default:
+	}
+}
+
+// setState must be called with b.mu held.
+func (b *circuitBreaker) setState(next breakerState) {
+	prev := b.state
+	b.state = next
+	b.metrics.set("gateway_router_circuit_state", float64(next), "router", b.router)
+	if next == breakerOpen {
+		b.metrics.inc("gateway_router_circuit_opened_total", "router", b.router)
+	}
+	slog.Warn("router circuit state changed",
+		"router", b.router,
+		"from", prev.String(),
+		"to", next.String(),
+		"consecutive_failures", b.failures,
+	)
+}
+
+// transport wraps base so that requests to host go through the breaker.
+func (b *circuitBreaker) transport(base http.RoundTripper, host string) http.RoundTripper {
+	return &breakerTransport{breaker: b, base: base, host: host}
+}
+
+type breakerTransport struct {
+	breaker *circuitBreaker
+	base    http.RoundTripper
+	host    string
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host {
+		return t.base.RoundTrip(req)
+	}
+
+	probe, err := t.breaker.allow()
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		t.breaker.metrics.inc("gateway_router_rejected_total", "router", t.breaker.router)
+		return nil, err
+	}
+
+	req, replayable := t.replayable(req)
+	resp, err := t.base.RoundTrip(req)
+	for attempt := 1; attempt <= t.breaker.settings.Retries && replayable && isDialError(err); attempt++ {
+		if !sleepContext(req.Context(), time.Duration(attempt)*100*time.Millisecond) {
+			break
+		}
+		body, bodyErr := req.GetBody()
+		if bodyErr != nil {
+			break
+		}
+		req.Body = body
+		t.breaker.metrics.inc("gateway_router_retries_total", "router", t.breaker.router)
+		resp, err = t.base.RoundTrip(req)
+	}
+
+	t.breaker.record(probe, classifyOutcome(resp, err))
+	return resp, err
+}
+
+// replayable buffers small request bodies so a failed dial can be retried.
+func (t *breakerTransport) replayable(req *http.Request) (*http.Request, bool) {
+	if t.breaker.settings.Retries <= 0 {
+		return req, false
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, true
+	}
+	if req.GetBody != nil {
+		return req, true
+	}
+	if req.ContentLength < 0 || req.ContentLength > maxReplayBodyBytes {
+		return req, false
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(req.Body, maxReplayBodyBytes+1))
+	req.Body.Close()
+	out := req.WithContext(req.Context())
+	out.Body = io.NopCloser(bytes.NewReader(payload))
+	if err != nil || int64(len(payload)) != req.ContentLength {
+		// Let the base transport report the short body as usual.
+		return out, false
+	}
+	out.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(payload)), nil
+	}
+	return out, true
+}
+
+func classifyOutcome(resp *http.Response, err error) breakerOutcome {
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return outcomeIgnored
+		}
+		return outcomeFailure
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return outcomeFailure
+	default:
+		return outcomeSuccess
+	}
+}
+
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/openpcc/ohttp"
+)
+
+const defaultTruncateAfterBytes = 32
+
+// faultProfile describes the faults injected for client resilience testing.
+// Percentages are in the range 0-100 and are rolled independently per request.
+type faultProfile struct {
+	LatencyMS          int     `json:"latency_ms"`
+	LatencyPercent     float64 `json:"latency_percent"`
+	ErrorStatus        int     `json:"error_status"`
+	ErrorPercent       float64 `json:"error_percent"`
+	TruncatePercent    float64 `json:"truncate_percent"`
+	TruncateAfterBytes int     `json:"truncate_after_bytes"`
+	UnknownKeyPercent  float64 `json:"unknown_key_percent"`
+}
+
+func parseFaultProfile(raw []byte) (*faultProfile, error) {
+	var profile faultProfile
+	if err := json.Unmarshal(raw, &profile); err != nil {
+		return nil, err
+	}
+	if err := profile.validate(); err != nil {
+		return nil, err
+	}
+	if profile.TruncateAfterBytes == 0 {
+		profile.TruncateAfterBytes = defaultTruncateAfterBytes
+	}
+	return &profile, nil
+}
+
+func (p faultProfile) validate() error {
+	for name, pct := range map[string]float64{
+		"latency_percent":     p.LatencyPercent,
+		"error_percent":       p.ErrorPercent,
+		"truncate_percent":    p.TruncatePercent,
+		"unknown_key_percent": p.UnknownKeyPercent,
+	} {
+		if pct < 0 || pct > 100 {
+			return fmt.Errorf("%s must be between 0 and 100", name)
+		}
+	}
+	if p.LatencyMS < 0 {
+		return errors.New("latency_ms must not be negative")
+	}
+	if p.TruncateAfterBytes < 0 {
+		return errors.New("truncate_after_bytes must not be negative")
+	}
+	if p.ErrorPercent > 0 && (p.ErrorStatus < 400 || p.ErrorStatus > 599) {
+		return errors.New("error_status must be a 4xx or 5xx status when error_percent is set")
+	}
+	return nil
+}
+
+// faultInjector holds the active fault profile. It is off until a profile is
+// set with -fault-injection or through the admin API.
+type faultInjector struct {
+	metrics *metricsRegistry
+
+	mu      sync.RWMutex
+	profile *faultProfile
+}
+
+func newFaultInjector(metrics *metricsRegistry) *faultInjector {
+	return &faultInjector{metrics: metrics}
+}
+
+func (f *faultInjector) set(profile *faultProfile) {
+	f.mu.Lock()
+	f.profile = profile
+	f.mu.Unlock()
+
+	if profile == nil {
+		slog.Warn("fault injection disabled")
+		f.metrics.set("gateway_fault_injection_enabled", 0)
+		return
+	}
+	slog.Warn("fault injection enabled; do not use in production", "profile", *profile)
+	f.metrics.set("gateway_fault_injection_enabled", 1)
+}
+
+func (f *faultInjector) current() *faultProfile {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.profile
+}
+
+func (f *faultInjector) roll(pct float64, kind string) bool {
+	if pct <= 0 || rand.Float64()*100 >= pct {
+		return false
+	}
+	f.metrics.inc("gateway_faults_injected_total", "kind", kind)
+	return true
+}
+
+// outer injects faults that are visible before decapsulation: latency,
+// unknown key IDs and truncated encapsulated responses.
+func (f *faultInjector) outer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		profile := f.current()
+		if profile == nil || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if f.roll(profile.LatencyPercent, "latency") {
+			if !sleepContext(r.Context(), time.Duration(profile.LatencyMS)*time.Millisecond) {
+				return
+			}
+		}
+		if f.roll(profile.UnknownKeyPercent, "unknown_key") {
+			handler := &ohttp.JSONProblemErrorHandler{}
+			handler.HandleError(w, false, ohttp.GatewayError{
+				Code: ohttp.ErrorCodeKeyNotFound,
+				Err:  errors.New("injected unknown key ID"),
+			})
+			return
+		}
+		if f.roll(profile.TruncatePercent, "truncate") {
+			next.ServeHTTP(&truncatingWriter{ResponseWriter: w, remaining: profile.TruncateAfterBytes}, r)
+			// Abort the connection so the client sees a cut-off body rather than
+			// a short but complete response.
+			panic(http.ErrAbortHandler)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// inner injects error statuses after decapsulation, so that they reach the
+// client encapsulated like a real router error.
+func (f *faultInjector) inner(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		profile := f.current()
+		if profile != nil && f.roll(profile.ErrorPercent, "error_status") {
+			http.Error(w, "Injected fault", profile.ErrorStatus)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ServeHTTP implements the admin API: GET shows the active profile, PUT
+// replaces it and DELETE turns fault injection off.
+func (f *faultInjector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		profile := f.current()
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"enabled": profile != nil,
+			"profile": profile,
+		})
+	case http.MethodPut:
+		raw, err := readAdminBody(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		profile, err := parseFaultProfile(raw)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid fault profile: %v", err), http.StatusBadRequest)
+			return
+		}
+		f.set(profile)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		f.set(nil)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+type truncatingWriter struct {
+	http.ResponseWriter
+	remaining int
+}
+
+func (t *truncatingWriter) Write(p []byte) (int, error) {
+	if t.remaining <= 0 {
+		return len(p), nil
+	}
+	n := min(len(p), t.remaining)
+	t.remaining -= n
+	if _, err := t.ResponseWriter.Write(p[:n]); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (t *truncatingWriter) Flush() {
+	if flusher, ok := t.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
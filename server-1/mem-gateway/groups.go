@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const defaultGroupName = "default"
+
+type seedGroup struct {
+	Name      string      `json:"name"`
+	RouterURL string      `json:"router_url"`
+	Keys      []seedSpec  `json:"keys"`
+	Limits    groupLimits `json:"limits"`
+}
+
+type groupLimits struct {
+	// MaxBodyBytes caps the size of an encapsulated request body. Zero means no limit.
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	// MaxConcurrentRequests caps the in-flight requests of the group. Zero means no limit.
+	MaxConcurrentRequests int `json:"max_concurrent_requests"`
+}
+
+// keyGroup is an isolated set of keys that share a router URL and limits.
+// An empty RouterURL means GATEWAY_ROUTER_URL.
+type keyGroup struct {
+	Name      string
+	RouterURL string
+	Keys      []gatewayKey
+	Limits    groupLimits
+}
+
+func toKeyGroups(groups []seedGroup) ([]keyGroup, error) {
+	result := make([]keyGroup, 0, len(groups))
+	names := map[string]struct{}{}
+	for idx, group := range groups {
+		name := strings.TrimSpace(group.Name)
+		if name == "" {
+			return nil, fmt.Errorf("groups[%d].name is required", idx)
+		}
+		if _, ok := names[name]; ok {
+			return nil, fmt.Errorf("groups[%d].name %q is duplicated", idx, name)
+		}
+		names[name] = struct{}{}
+		if len(group.Keys) == 0 {
+			return nil, fmt.Errorf("groups[%d].keys must not be empty", idx)
+		}
+		if group.Limits.MaxBodyBytes < 0 {
+			return nil, fmt.Errorf("groups[%d].limits.max_body_bytes must not be negative", idx)
+		}
+		if group.Limits.MaxConcurrentRequests < 0 {
+			return nil, fmt.Errorf("groups[%d].limits.max_concurrent_requests must not be negative", idx)
+		}
+
+		keys, err := toGatewayKeys(group.Keys)
+		if err != nil {
+			return nil, fmt.Errorf("groups[%d]: %w", idx, err)
+		}
+		result = append(result, keyGroup{
+			Name:      name,
+			RouterURL: strings.TrimSpace(group.RouterURL),
+			Keys:      keys,
+			Limits:    group.Limits,
+		})
+	}
+	return result, nil
+}
+
+// groupLimiter enforces the limits of one key group across all of its
+// router pools. Its responses are not encapsulated, so they never name the
+// group; rejections are logged and counted per group instead.
+type groupLimiter struct {
+	name    string
+	limits  groupLimits
+	slots   chan struct{}
+	metrics *metricsRegistry
+}
+
+func newGroupLimiter(name string, limits groupLimits, metrics *metricsRegistry) *groupLimiter {
+	limiter := &groupLimiter{name: name, limits: limits, metrics: metrics}
+	if limits.MaxConcurrentRequests > 0 {
+		limiter.slots = make(chan struct{}, limits.MaxConcurrentRequests)
+	}
+	return limiter
+}
+
+func (l *groupLimiter) wrap(next http.Handler) http.Handler {
+	if l.limits.MaxBodyBytes == 0 && l.slots == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.slots != nil {
+			select {
+			case l.slots <- struct{}{}:
+				defer func() { <-l.slots }()
+			default:
+				l.metrics.inc("gateway_group_rejected_total", "group", l.name, "reason", "concurrency")
+				slog.Warn("group is at its concurrency limit", "group", l.name)
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+		}
+		if l.limits.MaxBodyBytes > 0 {
+			if r.ContentLength > l.limits.MaxBodyBytes {
+				l.metrics.inc("gateway_group_rejected_total", "group", l.name, "reason", "body_size")
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, l.limits.MaxBodyBytes)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
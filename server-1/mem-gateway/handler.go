@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/openpcc/ohttp"
+	obhttp "github.com/openpcc/ohttp/encoding/bhttp"
+	"github.com/openpcc/openpcc/chunk"
+	"github.com/openpcc/openpcc/gateway"
+	"github.com/openpcc/openpcc/httpfmt"
+	"github.com/openpcc/openpcc/keyrotation"
+	"github.com/openpcc/openpcc/messages"
+	"github.com/openpcc/openpcc/otel/otelutil"
+)
+
+// poolConfig describes one gateway handler. It mirrors gateway.Config and
+// adds the hooks mem-gateway needs on the router hop.
+type poolConfig struct {
+	Keys      []gatewayKey
+	BankURL   string
+	RouterURL string
+	// Metrics counts requests accepted under a key's grace period.
+	Metrics *metricsRegistry
+	// Breaker guards requests to RouterURL. Nil disables it.
+	Breaker *circuitBreaker
+	// Faults injects error statuses after decapsulation. Nil disables it.
+	Faults *faultInjector
+	// Revocations refuses revoked keys. Nil disables revocation.
+	Revocations *revocationStore
+	// InnerHeaders strips decapsulated request headers. Nil forwards them as-is.
+	InnerHeaders *headerFilter
+}
+
+// newGatewayHandler assembles the same handler as gateway.NewGateway from the
+// exported upstream pieces, so that the router transport can be wrapped.
+func newGatewayHandler(cfg poolConfig) (http.Handler, error) {
+	bankURL, err := url.Parse(cfg.BankURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse bank URL: %w", err)
+	}
+	routerURL, err := url.Parse(cfg.RouterURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse router URL: %w", err)
+	}
+
+	reqDecoder, err := obhttp.NewRequestDecoder(
+		obhttp.FixedLengthResponseChunks(),
+		obhttp.MaxResponseChunkLen(messages.EncapsulatedChunkLen()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request decoder: %w", err)
+	}
+
+	keyPairs := make(graceKeyPairs, 0, len(cfg.Keys))
+	fingerprints := make(map[byte]string, len(cfg.Keys))
+	activeUntil := make(map[byte]time.Time, len(cfg.Keys))
+	for _, key := range cfg.Keys {
+		kp, err := key.expiringKeyPair()
+		if err != nil {
+			return nil, err
+		}
+		fingerprints[key.ID], err = keyFingerprint(kp.KeyConfig.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fingerprint key %d: %w", key.ID, err)
+		}
+		activeUntil[key.ID] = key.ActiveUntil
+		keyPairs = append(keyPairs, graceKeyPair{
+			ExpiringKeyPair: kp,
+			grace:           key.GracePeriod,
+			metrics:         cfg.Metrics,
+		})
+	}
+
+	ohttpGateway, err := ohttp.NewGateway(
+		graceUsage{
+			next:        revocationFinder{next: keyPairs, store: cfg.Revocations, fingerprints: fingerprints},
+			activeUntil: activeUntil,
+			metrics:     cfg.Metrics,
+		},
+		ohttp.WithRequestValidator(ohttp.NewHostnameAllowlist(gateway.ExternalBankHost, gateway.ExternalRouterHost)),
+		ohttp.WithRequestDecoder(reqDecoder),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ohttp gateway: %w", err)
+	}
+
+	proxy := newProxyHandler(bankURL, routerURL, cfg.Breaker)
+	decapHandler := limitEndpoints(proxy)
+	if cfg.Faults != nil {
+		decapHandler = cfg.Faults.inner(decapHandler)
+	}
+	if cfg.InnerHeaders != nil {
+		decapHandler = cfg.InnerHeaders.wrap(decapHandler)
+	}
+
+	mux := http.NewServeMux()
+	otelutil.ServeMuxHandle(mux, "POST /", ohttp.Middleware(ohttpGateway, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Confsec-Ping") == "gateway" {
+			if _, err := w.Write([]byte("gateway")); err != nil {
+				slog.Error("failed to write ping response", "err", err)
+			}
+			return
+		}
+		decapHandler.ServeHTTP(w, r)
+	})))
+	mux.Handle("GET /_health", http.HandlerFunc(httpfmt.JSONHealthCheck))
+
+	return mux, nil
+}
+
+func newProxyHandler(bankURL, routerURL *url.URL, breaker *circuitBreaker) http.Handler {
+	var transport http.RoundTripper = chunk.NewHTTPTransport(chunk.DefaultDialTimeout)
+	if breaker != nil {
+		transport = breaker.transport(transport, routerURL.Host)
+	}
+	return &httputil.ReverseProxy{
+		FlushInterval: 0,
+		Transport:     otelutil.NewTransport(transport),
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			ctx, span := otelutil.Tracer.Start(pr.In.Context(), "gateway.ReverseProxy")
+			defer span.End()
+			pr.Out = pr.Out.WithContext(ctx)
+
+			switch pr.In.Host {
+			case gateway.ExternalBankHost:
+				pr.SetURL(bankURL)
+			case gateway.ExternalRouterHost:
+				pr.SetURL(routerURL)
+			default:
+			}
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			var openErr *circuitOpenError
+			if errors.As(err, &openErr) {
+				w.Header().Set("Retry-After", openErr.retryAfterSeconds())
+				http.Error(w, "Router unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			slog.ErrorContext(r.Context(), "proxy error", "error", err, "method", r.Method, "path", r.URL.Path)
+			http.Error(w, "Proxy error", http.StatusBadGateway)
+		},
+	}
+}
+
+// limitEndpoints only lets allow-listed bank and router endpoints through,
+// matching the upstream gateway.
+func limitEndpoints(next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	endpoints := []string{
+		"POST " + gateway.ExternalBankHost + "/deposit",
+		"POST " + gateway.ExternalBankHost + "/exchange",
+		"POST " + gateway.ExternalBankHost + "/withdraw",
+		"POST " + gateway.ExternalBankHost + "/withdraw-full",
+		"POST " + gateway.ExternalBankHost + "/balance",
+		"GET " + gateway.ExternalRouterHost + "/ping",
+		"POST " + gateway.ExternalRouterHost + "/compute-manifests",
+		"POST " + gateway.ExternalRouterHost + "/{$}",
+	}
+	for _, endpoint := range endpoints {
+		mux.Handle(endpoint, next)
+	}
+	return mux
+}
+
+func newExpiringKeyPair(id byte, seed []byte, suite hpkeSuite, activeFrom, activeUntil time.Time) gateway.ExpiringKeyPair {
+	pubKey, secretKey := suite.KEM.Scheme().DeriveKeyPair(seed)
+
+	return gateway.ExpiringKeyPair{
+		KeyPair: ohttp.KeyPair{
+			SecretKey: secretKey,
+			KeyConfig: ohttp.KeyConfig{
+				KeyID:     id,
+				KemID:     suite.KEM,
+				PublicKey: pubKey,
+				SymmetricAlgorithms: []ohttp.SymmetricAlgorithm{
+					{
+						KDFID:  suite.KDF,
+						AEADID: suite.AEAD,
+					},
+				},
+			},
+		},
+		Period: keyrotation.Period{
+			ActiveFrom:  activeFrom,
+			ActiveUntil: activeUntil,
+		},
+	}
+}
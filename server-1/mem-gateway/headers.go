@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/openpcc/openpcc/router/api"
+)
+
+// maxTrackedStrippedHeaders bounds how many distinct stripped header names are
+// remembered for the debug endpoint. Values are never recorded.
+const maxTrackedStrippedHeaders = 256
+
+// defaultOuterHeaders are the encapsulated request headers the gateway itself
+// needs. The relay signature is checked before stripping, so it is not listed.
+var defaultOuterHeaders = []string{
+	"Content-Type",
+	"Content-Length",
+	"Incremental",
+}
+
+// defaultInnerHeaders are the decapsulated request headers the bank and router
+// need from the client.
+var defaultInnerHeaders = []string{
+	"Content-Type",
+	"Content-Length",
+	"Accept",
+	api.RoutingInfoHeader,
+	api.CreditHeader,
+	"X-Confsec-Ping",
+}
+
+// forwardingHeaders identify the user or the relay path. They are always
+// stripped and cannot be added to either allow-list.
+var forwardingHeaders = []string{
+	"Forwarded",
+	"Via",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Real-Ip",
+	"True-Client-Ip",
+	"Cf-Connecting-Ip",
+	"X-Client-Ip",
+}
+
+// headerFilter drops every header that is not on its allow-list.
+type headerFilter struct {
+	scope   string
+	allowed map[string]struct{}
+	metrics *metricsRegistry
+
+	mu       sync.Mutex
+	stripped map[string]uint64
+}
+
+func newHeaderFilter(scope string, defaults []string, extra string, metrics *metricsRegistry) (*headerFilter, error) {
+	f := &headerFilter{
+		scope:    scope,
+		allowed:  map[string]struct{}{},
+		metrics:  metrics,
+		stripped: map[string]uint64{},
+	}
+	for _, name := range defaults {
+		f.allowed[http.CanonicalHeaderKey(name)] = struct{}{}
+	}
+	for _, name := range strings.Split(extra, ",") {
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if slices.Contains(forwardingHeaders, name) {
+			return nil, fmt.Errorf("%s is forwarding metadata and cannot be allowed", name)
+		}
+		f.allowed[name] = struct{}{}
+	}
+	return f, nil
+}
+
+// loadHeaderFilters builds the outer and inner filters. GATEWAY_OUTER_HEADERS
+// and GATEWAY_INNER_HEADERS add comma-separated names to the defaults.
+func loadHeaderFilters(metrics *metricsRegistry) (*headerFilter, *headerFilter, error) {
+	outer, err := newHeaderFilter("outer", defaultOuterHeaders, os.Getenv("GATEWAY_OUTER_HEADERS"), metrics)
+	if err != nil {
+		return nil, nil, fmt.Errorf("GATEWAY_OUTER_HEADERS: %w", err)
+	}
+	inner, err := newHeaderFilter("inner", defaultInnerHeaders, os.Getenv("GATEWAY_INNER_HEADERS"), metrics)
+	if err != nil {
+		return nil, nil, fmt.Errorf("GATEWAY_INNER_HEADERS: %w", err)
+	}
+	return outer, inner, nil
+}
+
+// evaluate reports which header names would be stripped, without modifying h.
+func (f *headerFilter) evaluate(h http.Header) []string {
+	var stripped []string
+	for name := range h {
+		canonical := http.CanonicalHeaderKey(name)
+		if _, ok := f.allowed[canonical]; !ok {
+			stripped = append(stripped, canonical)
+		}
+	}
+	slices.Sort(stripped)
+	return slices.Compact(stripped)
+}
+
+// strip removes disallowed headers from h in place.
+func (f *headerFilter) strip(h http.Header) {
+	for name := range h {
+		canonical := http.CanonicalHeaderKey(name)
+		if _, ok := f.allowed[canonical]; ok {
+			continue
+		}
+		delete(h, name)
+		f.record(canonical)
+	}
+}
+
+func (f *headerFilter) record(name string) {
+	f.metrics.inc("gateway_headers_stripped_total", "scope", f.scope)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.stripped[name]; ok || len(f.stripped) < maxTrackedStrippedHeaders {
+		f.stripped[name]++
+	}
+}
+
+func (f *headerFilter) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.strip(r.Header)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (f *headerFilter) allowList() []string {
+	names := make([]string, 0, len(f.allowed))
+	for name := range f.allowed {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (f *headerFilter) strippedCounts() map[string]uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	counts := make(map[string]uint64, len(f.stripped))
+	for name, n := range f.stripped {
+		counts[name] = n
+	}
+	return counts
+}
+
+// headerAudit serves the admin debug endpoint. GET reports both allow-lists and
+// the header names stripped so far. POST takes {"outer": {...}, "inner": {...}}
+// header maps and reports which names would be stripped.
+type headerAudit struct {
+	outer *headerFilter
+	inner *headerFilter
+}
+
+func (a headerAudit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"forwarding": forwardingHeaders,
+			"outer": map[string]any{
+				"allowed":  a.outer.allowList(),
+				"stripped": a.outer.strippedCounts(),
+			},
+			"inner": map[string]any{
+				"allowed":  a.inner.allowList(),
+				"stripped": a.inner.strippedCounts(),
+			},
+		})
+	case http.MethodPost:
+		raw, err := readAdminBody(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var probe struct {
+			Outer http.Header `json:"outer"`
+			Inner http.Header `json:"inner"`
+		}
+		if err := json.Unmarshal(raw, &probe); err != nil {
+			http.Error(w, fmt.Sprintf("invalid header probe: %v", err), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"outer": map[string]any{"stripped": nonNil(a.outer.evaluate(probe.Outer))},
+			"inner": map[string]any{"stripped": nonNil(a.inner.evaluate(probe.Inner))},
+		})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func nonNil(names []string) []string {
+	if names == nil {
+		return []string{}
+	}
+	return names
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/openpcc/ohttp"
+)
+
+const ohttpKeysMediaType = "application/ohttp-keys"
+
+// keyPeriod is the rotation period of one published key. The shape matches
+// server-3's ohttp_key_rotation_periods.
+type keyPeriod struct {
+	KeyID       string    `json:"key_id"`
+	ActiveFrom  time.Time `json:"active_from"`
+	ActiveUntil time.Time `json:"active_until"`
+}
+
+// keyConfigPublisher serves the public half of the gateway keys, so clients
+// can encapsulate without holding any seed. Revoked keys and keys past
+// ActiveUntil are left out; keys in their grace period are still accepted but
+// no longer offered.
+type keyConfigPublisher struct {
+	keys        []ohttp.KeyConfig
+	periods     []keyPeriod
+	revocations *revocationStore
+}
+
+func newKeyConfigPublisher(groups []keyGroup, revocations *revocationStore) (*keyConfigPublisher, error) {
+	publisher := &keyConfigPublisher{revocations: revocations}
+	for _, group := range groups {
+		for _, key := range group.Keys {
+			kp, err := key.expiringKeyPair()
+			if err != nil {
+				return nil, err
+			}
+			publisher.keys = append(publisher.keys, kp.KeyConfig)
+			publisher.periods = append(publisher.periods, keyPeriod{
+				KeyID:       strconv.Itoa(int(key.ID)),
+				ActiveFrom:  key.ActiveFrom,
+				ActiveUntil: key.ActiveUntil,
+			})
+		}
+	}
+	return publisher, nil
+}
+
+func (p *keyConfigPublisher) current() (ohttp.KeyConfigs, []keyPeriod, error) {
+	now := time.Now()
+	var keys ohttp.KeyConfigs
+	var periods []keyPeriod
+	for idx, kc := range p.keys {
+		if now.After(p.periods[idx].ActiveUntil) {
+			continue
+		}
+		fingerprint, err := keyFingerprint(kc.PublicKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to fingerprint key %d: %w", kc.KeyID, err)
+		}
+		if _, revoked := p.revocations.revoked(kc.KeyID, fingerprint); revoked {
+			continue
+		}
+		keys = append(keys, kc)
+		periods = append(periods, p.periods[idx])
+	}
+	return keys, periods, nil
+}
+
+// serveKeyConfigs implements the RFC 9540 gateway key configuration resource.
+func (p *keyConfigPublisher) serveKeyConfigs(w http.ResponseWriter, r *http.Request) {
+	keys, _, err := p.current()
+	if err == nil && len(keys) == 0 {
+		err = fmt.Errorf("no key to publish")
+	}
+	var body []byte
+	if err == nil {
+		body, err = keys.MarshalBinary()
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to publish key configs", "err", err)
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", ohttpKeysMediaType)
+	w.Header().Set("Cache-Control", "max-age=300")
+	_, _ = w.Write(body)
+}
+
+func (p *keyConfigPublisher) servePeriods(w http.ResponseWriter, r *http.Request) {
+	_, periods, err := p.current()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to publish key periods", "err", err)
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age=300")
+	_ = json.NewEncoder(w).Encode(map[string]any{"ohttp_key_rotation_periods": periods})
+}
+
+// wrap serves the published key material next to the oHTTP endpoint.
+func (p *keyConfigPublisher) wrap(next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/ohttp-gateway", p.serveKeyConfigs)
+	mux.HandleFunc("GET /.well-known/ohttp-gateway/periods", p.servePeriods)
+	mux.Handle("/", next)
+	return mux
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/openpcc/ohttp"
+	"github.com/openpcc/openpcc/gateway"
+	"github.com/openpcc/openpcc/keyrotation"
+	"github.com/openpcc/twoway"
+)
+
+// gatewayKey is a gateway.Key with its HPKE suite and an optional post-expiry
+// grace period.
+type gatewayKey struct {
+	gateway.Key
+	Suite hpkeSuite
+	// GracePeriod keeps accepting the key for this long after ActiveUntil.
+	GracePeriod time.Duration
+}
+
+func (k gatewayKey) expiringKeyPair() (gateway.ExpiringKeyPair, error) {
+	seed, err := hex.DecodeString(k.Seed)
+	if err != nil {
+		return gateway.ExpiringKeyPair{}, fmt.Errorf("failed to decode key seed from hex: %w", err)
+	}
+	return newExpiringKeyPair(k.ID, seed, k.Suite, k.ActiveFrom, k.ActiveUntil), nil
+}
+
+// fingerprint is the value revocation lists use to name this key.
+func (k gatewayKey) fingerprint() (string, error) {
+	kp, err := k.expiringKeyPair()
+	if err != nil {
+		return "", err
+	}
+	return keyFingerprint(kp.KeyConfig.PublicKey)
+}
+
+// graceKeyPair behaves like gateway.ExpiringKeyPair, but keeps accepting an
+// expired key during its grace period.
+type graceKeyPair struct {
+	gateway.ExpiringKeyPair
+	grace   time.Duration
+	metrics *metricsRegistry
+}
+
+func (k graceKeyPair) FindSecretKey(ctx context.Context, header twoway.RequestHeader) (ohttp.SecretKeyInfo, error) {
+	info, err := k.ExpiringKeyPair.FindSecretKey(ctx, header)
+	if k.grace <= 0 || !errors.Is(err, keyrotation.ErrorKeyExpired) {
+		return info, err
+	}
+
+	keyID := strconv.Itoa(int(k.KeyConfig.KeyID))
+	expiredFor := time.Since(k.ActiveUntil)
+	if expiredFor > k.grace {
+		k.metrics.inc("gateway_key_expired_rejected_total", "key_id", keyID)
+		return ohttp.SecretKeyInfo{}, err
+	}
+
+	return k.KeyPair.FindSecretKey(ctx, header)
+}
+
+// graceKeyPairs is gateway.KeyPairs for graceKeyPair.
+type graceKeyPairs []graceKeyPair
+
+func (kps graceKeyPairs) FindSecretKey(ctx context.Context, header twoway.RequestHeader) (ohttp.SecretKeyInfo, error) {
+	var lastErr error
+	for _, kp := range kps {
+		info, err := kp.FindSecretKey(ctx, header)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return info, nil
+	}
+	return ohttp.SecretKeyInfo{}, lastErr
+}
+
+// graceUsage counts requests accepted under a key's grace period per key ID,
+// so operators can tell when a rotated-out key is unused. It wraps the
+// revocation check, so a revoked key is not counted as in use.
+type graceUsage struct {
+	next        ohttp.SecretKeyFinder
+	activeUntil map[byte]time.Time
+	metrics     *metricsRegistry
+}
+
+func (g graceUsage) FindSecretKey(ctx context.Context, header twoway.RequestHeader) (ohttp.SecretKeyInfo, error) {
+	info, err := g.next.FindSecretKey(ctx, header)
+	if err != nil {
+		return info, err
+	}
+	activeUntil, ok := g.activeUntil[info.KeyID]
+	if !ok {
+		return info, nil
+	}
+	// Keys past ActiveUntil are only found under grace.
+	if expiredFor := time.Since(activeUntil); expiredFor > 0 {
+		keyID := strconv.Itoa(int(info.KeyID))
+		g.metrics.inc("gateway_key_grace_requests_total", "key_id", keyID)
+		g.metrics.set("gateway_key_grace_last_used_timestamp_seconds", float64(time.Now().Unix()), "key_id", keyID)
+		slog.DebugContext(ctx, "accepted expired key under grace period", "key_id", keyID, "expired_for", expiredFor)
+	}
+	return info, nil
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
@@ -17,21 +19,54 @@ type seedSpec struct {
 	SeedHex     string `json:"seed_hex"`
 	ActiveFrom  string `json:"active_from"`
 	ActiveUntil string `json:"active_until"`
+	// GracePeriod is a Go duration such as "10m". Empty means no grace.
+	GracePeriod string `json:"grace_period"`
+	// Suite names an entry of hpkeSuites. Empty means defaultSuiteName.
+	Suite string `json:"suite"`
 }
 
 type seedEnvelope struct {
-	OHTTPKeys  []seedSpec `json:"OHTTP_KEYS"`
-	OHTTPSeeds []seedSpec `json:"ohttp_seeds"`
+	OHTTPKeys  []seedSpec  `json:"OHTTP_KEYS"`
+	OHTTPSeeds []seedSpec  `json:"ohttp_seeds"`
+	Groups     []seedGroup `json:"groups"`
 }
 
 func main() {
+	faultProfileJSON := flag.String("fault-injection", "", "JSON fault profile for client resilience testing (test only)")
+	flag.Parse()
+
 	listenAddr := getenv("GATEWAY_LISTEN_ADDR", ":3200")
 	bankURL := getenv("GATEWAY_BANK_URL", "http://localhost:3500")
 	routerURL := getenv("GATEWAY_ROUTER_URL", "http://localhost:3600")
 	seedsJSON := strings.TrimSpace(os.Getenv("OHTTP_SEEDS_JSON"))
 	seedsRef := strings.TrimSpace(os.Getenv("OHTTP_SEEDS_SECRET_REF"))
+	adminAddr := getenv("GATEWAY_ADMIN_ADDR", "127.0.0.1:3201")
+	keyRoutes, err := parseKeyRoutes(os.Getenv("GATEWAY_KEY_ROUTES"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to parse GATEWAY_KEY_ROUTES: %v\n", err)
+		os.Exit(1)
+	}
+	breaker, err := loadBreakerSettings()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid circuit breaker settings: %v\n", err)
+		os.Exit(1)
+	}
+	relayAuth, err := loadRelayAuthConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid relay authentication settings: %v\n", err)
+		os.Exit(1)
+	}
+	tlsCertFile := strings.TrimSpace(os.Getenv("GATEWAY_TLS_CERT_FILE"))
+	tlsKeyFile := strings.TrimSpace(os.Getenv("GATEWAY_TLS_KEY_FILE"))
+	if relayAuth.ClientCAs != nil && (tlsCertFile == "" || tlsKeyFile == "") {
+		fmt.Fprintln(os.Stderr, "GATEWAY_RELAY_CLIENT_CA_FILE requires GATEWAY_TLS_CERT_FILE and GATEWAY_TLS_KEY_FILE")
+		os.Exit(1)
+	}
+	if len(relayAuth.HMACSecret) > 0 {
+		fmt.Fprintln(os.Stderr, "WARNING: relay HMAC is enabled (GATEWAY_RELAY_HMAC_SECRET); the bundled server-4 relay does not sign requests, so every POST is rejected unless a signing proxy sits between the relay and this gateway.")
+	}
 
-	keys, usedEnv, err := loadKeysFromJSON(seedsJSON)
+	groups, usedEnv, err := loadKeyGroupsFromJSON(seedsJSON)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to parse OHTTP_SEEDS_JSON: %v\n", err)
 		os.Exit(1)
@@ -47,29 +82,102 @@ func main() {
 			os.Exit(1)
 		}
 		fmt.Fprintln(os.Stderr, "OHTTP_SEEDS_JSON not set; using default gateway seed")
-		keys = []gateway.Key{defaultKey}
+		groups = []keyGroup{{Name: defaultGroupName, Keys: []gatewayKey{{Key: defaultKey, Suite: defaultSuite()}}}}
+	}
+
+	metrics := newMetricsRegistry()
+	faultsEnabled, err := getenvBool("GATEWAY_FAULT_INJECTION")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid fault injection settings: %v\n", err)
+		os.Exit(1)
+	}
+	if *faultProfileJSON != "" && !faultsEnabled {
+		fmt.Fprintln(os.Stderr, "-fault-injection requires GATEWAY_FAULT_INJECTION=1")
+		os.Exit(2)
+	}
+	faults := newFaultInjector(metrics)
+	// Only mounted on the admin listener when fault injection is opted in.
+	var adminFaults *faultInjector
+	if faultsEnabled {
+		fmt.Fprintln(os.Stderr, "WARNING: fault injection is enabled (GATEWAY_FAULT_INJECTION); the admin listener can make this gateway fail requests. Never enable it in production.")
+		adminFaults = faults
+	}
+	if *faultProfileJSON != "" {
+		profile, err := parseFaultProfile([]byte(*faultProfileJSON))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -fault-injection profile: %v\n", err)
+			os.Exit(2)
+		}
+		faults.set(profile)
 	}
 
-	cfg := gateway.Config{
-		Keys:      keys,
-		BankURL:   bankURL,
-		RouterURL: routerURL,
+	outerHeaders, innerHeaders, err := loadHeaderFilters(metrics)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid header allow-list: %v\n", err)
+		os.Exit(1)
 	}
 
-	handler, err := gateway.NewGateway(cfg)
+	revocations, revocationPath, revocationRefresh, err := loadRevocationStore(metrics)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid revocation list settings: %v\n", err)
+		os.Exit(1)
+	}
+	go revocations.watch(context.Background(), revocationPath, revocationRefresh)
+
+	handler, err := newKeyRouter(groups, gatewayOptions{
+		BankURL:          bankURL,
+		DefaultRouterURL: routerURL,
+		Routes:           keyRoutes,
+		Breaker:          breaker,
+		Metrics:          metrics,
+		Faults:           faults,
+		InnerHeaders:     innerHeaders,
+		Revocations:      revocations,
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to create gateway: %v\n", err)
 		os.Exit(1)
 	}
 
-	// nosemgrep: go.lang.security.audit.net.use-tls.use-tls
-	if err := http.ListenAndServe(listenAddr, handler); err != nil {
+	keyConfigs, err := newKeyConfigPublisher(groups, revocations)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to build published key configs: %v\n", err)
+		os.Exit(1)
+	}
+
+	if adminAddr != "off" {
+		admin := newAdminHandler(metrics, adminFaults, headerAudit{outer: outerHeaders, inner: innerHeaders}, revocations)
+		go func() {
+			// nosemgrep: go.lang.security.audit.net.use-tls.use-tls
+			if err := http.ListenAndServe(adminAddr, admin); err != nil {
+				fmt.Fprintf(os.Stderr, "gateway admin listen failed: %v\n", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	// Relay headers are stripped after authentication, which needs the
+	// signature headers, and before anything else sees the request, the
+	// published key configs included.
+	authenticator := newRelayAuthenticator(relayAuth, metrics)
+	server := &http.Server{
+		Addr:      listenAddr,
+		Handler:   authenticator.wrap(outerHeaders.wrap(keyConfigs.wrap(faults.outer(handler)))),
+		TLSConfig: authenticator.tlsConfig(),
+	}
+	if tlsCertFile != "" || tlsKeyFile != "" {
+		err = server.ListenAndServeTLS(tlsCertFile, tlsKeyFile)
+	} else {
+		// nosemgrep: go.lang.security.audit.net.use-tls.use-tls
+		err = server.ListenAndServe()
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "gateway listen failed: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-func loadKeysFromJSON(raw string) ([]gateway.Key, bool, error) {
+func loadKeyGroupsFromJSON(raw string) ([]keyGroup, bool, error) {
 	if raw == "" {
 		return nil, false, nil
 	}
@@ -77,27 +185,46 @@ func loadKeysFromJSON(raw string) ([]gateway.Key, bool, error) {
 	var seeds []seedSpec
 	if err := json.Unmarshal([]byte(raw), &seeds); err == nil && len(seeds) > 0 {
 		keys, err := toGatewayKeys(seeds)
-		return keys, true, err
+		return []keyGroup{{Name: defaultGroupName, Keys: keys}}, true, err
 	}
 
 	var envelope seedEnvelope
 	if err := json.Unmarshal([]byte(raw), &envelope); err != nil {
 		return nil, true, err
 	}
-	switch {
-	case len(envelope.OHTTPKeys) > 0:
-		keys, err := toGatewayKeys(envelope.OHTTPKeys)
-		return keys, true, err
-	case len(envelope.OHTTPSeeds) > 0:
-		keys, err := toGatewayKeys(envelope.OHTTPSeeds)
-		return keys, true, err
-	default:
+
+	var groups []keyGroup
+	topLevel := envelope.OHTTPKeys
+	if len(topLevel) == 0 {
+		topLevel = envelope.OHTTPSeeds
+	}
+	if len(topLevel) > 0 {
+		keys, err := toGatewayKeys(topLevel)
+		if err != nil {
+			return nil, true, err
+		}
+		groups = append(groups, keyGroup{Name: defaultGroupName, Keys: keys})
+	}
+	if len(envelope.Groups) > 0 {
+		named, err := toKeyGroups(envelope.Groups)
+		if err != nil {
+			return nil, true, err
+		}
+		for _, group := range named {
+			if len(groups) > 0 && group.Name == defaultGroupName {
+				return nil, true, fmt.Errorf("group name %q is reserved for top-level keys", defaultGroupName)
+			}
+		}
+		groups = append(groups, named...)
+	}
+	if len(groups) == 0 {
 		return nil, true, fmt.Errorf("no seeds found in OHTTP_SEEDS_JSON")
 	}
+	return groups, true, nil
 }
 
-func toGatewayKeys(seeds []seedSpec) ([]gateway.Key, error) {
-	keys := make([]gateway.Key, 0, len(seeds))
+func toGatewayKeys(seeds []seedSpec) ([]gatewayKey, error) {
+	keys := make([]gatewayKey, 0, len(seeds))
 	for idx, seed := range seeds {
 		if strings.TrimSpace(seed.KeyID) == "" {
 			return nil, fmt.Errorf("seed[%d].key_id is required", idx)
@@ -124,12 +251,30 @@ func toGatewayKeys(seeds []seedSpec) ([]gateway.Key, error) {
 		if err != nil {
 			return nil, fmt.Errorf("seed[%d].active_until invalid: %w", idx, err)
 		}
+		suite, err := lookupSuite(seed.Suite)
+		if err != nil {
+			return nil, fmt.Errorf("seed[%d].suite invalid: %w", idx, err)
+		}
+		var grace time.Duration
+		if value := strings.TrimSpace(seed.GracePeriod); value != "" {
+			grace, err = time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("seed[%d].grace_period invalid: %w", idx, err)
+			}
+			if grace < 0 {
+				return nil, fmt.Errorf("seed[%d].grace_period must not be negative", idx)
+			}
+		}
 
-		keys = append(keys, gateway.Key{
-			ID:          keyID,
-			Seed:        seed.SeedHex,
-			ActiveFrom:  activeFrom,
-			ActiveUntil: activeUntil,
+		keys = append(keys, gatewayKey{
+			Key: gateway.Key{
+				ID:          keyID,
+				Seed:        seed.SeedHex,
+				ActiveFrom:  activeFrom,
+				ActiveUntil: activeUntil,
+			},
+			Suite:       suite,
+			GracePeriod: grace,
 		})
 	}
 	return keys, nil
@@ -178,6 +323,40 @@ func defaultGatewayKey() (gateway.Key, error) {
 	}, nil
 }
 
+func loadBreakerSettings() (breakerSettings, error) {
+	failures, err := getenvInt("GATEWAY_BREAKER_FAILURES", 5)
+	if err != nil {
+		return breakerSettings{}, err
+	}
+	cooldown, err := getenvDuration("GATEWAY_BREAKER_COOLDOWN", 30*time.Second)
+	if err != nil {
+		return breakerSettings{}, err
+	}
+	probes, err := getenvInt("GATEWAY_BREAKER_HALF_OPEN_PROBES", 1)
+	if err != nil {
+		return breakerSettings{}, err
+	}
+	retries, err := getenvInt("GATEWAY_ROUTER_RETRIES", 1)
+	if err != nil {
+		return breakerSettings{}, err
+	}
+	return breakerSettings{
+		FailureThreshold: failures,
+		Cooldown:         cooldown,
+		HalfOpenProbes:   probes,
+		Retries:          retries,
+	}, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
 func getenv(name, fallback string) string {
 	value := strings.TrimSpace(os.Getenv(name))
 	if value == "" {
@@ -185,3 +364,39 @@ func getenv(name, fallback string) string {
 	}
 	return value
 }
+
+func getenvBool(name string) (bool, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return false, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s must be a boolean, got %q", name, value)
+	}
+	return parsed, nil
+}
+
+func getenvInt(name string, fallback int) (int, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer, got %q", name, value)
+	}
+	return parsed, nil
+}
+
+func getenvDuration(name string, fallback time.Duration) (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative duration, got %q", name, value)
+	}
+	return parsed, nil
+}
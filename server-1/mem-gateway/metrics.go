@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metricsRegistry is a minimal in-process registry exposed in the Prometheus
+// text format on the admin listener.
+type metricsRegistry struct {
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	name   string
+	labels string
+	kind   string
+	value  float64
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{series: map[string]*metricSeries{}}
+}
+
+// inc increments a counter. Labels are given as name, value pairs.
+func (m *metricsRegistry) inc(name string, labels ...string) {
+	m.update(name, "counter", labels, func(s *metricSeries) { s.value++ })
+}
+
+// set stores the current value of a gauge. Labels are given as name, value pairs.
+func (m *metricsRegistry) set(name string, value float64, labels ...string) {
+	m.update(name, "gauge", labels, func(s *metricSeries) { s.value = value })
+}
+
+func (m *metricsRegistry) update(name, kind string, labels []string, apply func(*metricSeries)) {
+	if m == nil {
+		return
+	}
+	rendered := renderLabels(labels)
+	key := name + rendered
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series, ok := m.series[key]
+	if !ok {
+		series = &metricSeries{name: name, labels: rendered, kind: kind}
+		m.series[key] = series
+	}
+	apply(series)
+}
+
+func (m *metricsRegistry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	all := make([]metricSeries, 0, len(m.series))
+	for _, series := range m.series {
+		all = append(all, *series)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].name != all[j].name {
+			return all[i].name < all[j].name
+		}
+		return all[i].labels < all[j].labels
+	})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	lastName := ""
+	for _, series := range all {
+		if series.name != lastName {
+			fmt.Fprintf(w, "# TYPE %s %s\n", series.name, series.kind)
+			lastName = series.name
+		}
+		fmt.Fprintf(w, "%s%s %s\n", series.name, series.labels, strconv.FormatFloat(series.value, 'f', -1, 64))
+	}
+}
+
+func renderLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels)/2)
+	for idx := 0; idx+1 < len(labels); idx += 2 {
+		parts = append(parts, fmt.Sprintf("%s=%s", labels[idx], strconv.Quote(labels[idx+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	relaySignatureHeader      = "X-Relay-Signature"
+	relayTimestampHeader      = "X-Relay-Timestamp"
+	relaySignaturePrefix      = "sha256="
+	defaultMaxSignedBodyBytes = 8 << 20
+	defaultMaxSignatureSkew   = time.Minute
+)
+
+// relayAuthConfig restricts which relays may talk to the gateway. Every
+// configured method must pass; an empty config accepts all peers.
+type relayAuthConfig struct {
+	AllowedCIDRs       []netip.Prefix
+	ClientCAs          *x509.CertPool
+	HMACSecret         []byte
+	MaxSignedBodyBytes int64
+	// MaxSignatureSkew bounds how far X-Relay-Timestamp may be from the
+	// gateway clock. Signatures are remembered for this long, so each one is
+	// accepted once.
+	MaxSignatureSkew time.Duration
+}
+
+func loadRelayAuthConfig() (relayAuthConfig, error) {
+	var cfg relayAuthConfig
+
+	for _, entry := range strings.Split(os.Getenv("GATEWAY_RELAY_CIDRS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				return relayAuthConfig{}, fmt.Errorf("GATEWAY_RELAY_CIDRS entry %q invalid: %w", entry, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		cfg.AllowedCIDRs = append(cfg.AllowedCIDRs, prefix.Masked())
+	}
+
+	if path := strings.TrimSpace(os.Getenv("GATEWAY_RELAY_CLIENT_CA_FILE")); path != "" {
+		pemBytes, err := os.ReadFile(path)
+		if err != nil {
+			return relayAuthConfig{}, fmt.Errorf("failed to read GATEWAY_RELAY_CLIENT_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemBytes) {
+			return relayAuthConfig{}, fmt.Errorf("no certificates found in %s", path)
+		}
+		cfg.ClientCAs = pool
+	}
+
+	secret, err := readSecret("GATEWAY_RELAY_HMAC_SECRET", "GATEWAY_RELAY_HMAC_SECRET_FILE")
+	if err != nil {
+		return relayAuthConfig{}, err
+	}
+	cfg.HMACSecret = secret
+
+	maxBody, err := getenvInt("GATEWAY_RELAY_HMAC_MAX_BODY_BYTES", defaultMaxSignedBodyBytes)
+	if err != nil {
+		return relayAuthConfig{}, err
+	}
+	if len(cfg.HMACSecret) > 0 && maxBody < 1 {
+		return relayAuthConfig{}, fmt.Errorf("GATEWAY_RELAY_HMAC_MAX_BODY_BYTES must be at least 1 when an HMAC secret is set")
+	}
+	cfg.MaxSignedBodyBytes = int64(maxBody)
+
+	skew, err := getenvDuration("GATEWAY_RELAY_HMAC_MAX_SKEW", defaultMaxSignatureSkew)
+	if err != nil {
+		return relayAuthConfig{}, err
+	}
+	if skew <= 0 {
+		return relayAuthConfig{}, fmt.Errorf("GATEWAY_RELAY_HMAC_MAX_SKEW must be positive")
+	}
+	cfg.MaxSignatureSkew = skew
+	return cfg, nil
+}
+
+func readSecret(envName, fileEnvName string) ([]byte, error) {
+	if value := strings.TrimSpace(os.Getenv(envName)); value != "" {
+		return []byte(value), nil
+	}
+	path := strings.TrimSpace(os.Getenv(fileEnvName))
+	if path == "" {
+		return nil, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", fileEnvName, err)
+	}
+	secret := bytes.TrimSpace(raw)
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+	return secret, nil
+}
+
+type relayAuthenticator struct {
+	cfg     relayAuthConfig
+	metrics *metricsRegistry
+	now     func() time.Time
+
+	mu sync.Mutex
+	// seen holds the signatures accepted within MaxSignatureSkew and when
+	// they stop being valid.
+	seen       map[string]time.Time
+	lastPruned time.Time
+}
+
+func newRelayAuthenticator(cfg relayAuthConfig, metrics *metricsRegistry) *relayAuthenticator {
+	return &relayAuthenticator{cfg: cfg, metrics: metrics, now: time.Now, seen: map[string]time.Time{}}
+}
+
+func (a *relayAuthenticator) reject(w http.ResponseWriter, r *http.Request, method, reason string, status int) {
+	a.metrics.inc("gateway_relay_auth_rejected_total", "method", method)
+	slog.Warn("rejected relay request", "method", method, "reason", reason, "remote_addr", r.RemoteAddr)
+	http.Error(w, http.StatusText(status), status)
+}
+
+// wrap enforces the source CIDR allow-list and the HMAC signature. Mutual TLS
+// is enforced during the handshake by tlsConfig.
+func (a *relayAuthenticator) wrap(next http.Handler) http.Handler {
+	if len(a.cfg.AllowedCIDRs) == 0 && len(a.cfg.HMACSecret) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(a.cfg.AllowedCIDRs) > 0 && !a.allowedSource(r.RemoteAddr) {
+			a.reject(w, r, "cidr", "source address not allowed", http.StatusForbidden)
+			return
+		}
+		if len(a.cfg.HMACSecret) > 0 && r.Method == http.MethodPost {
+			body, status, reason := a.verifySignature(r)
+			if reason != "" {
+				a.reject(w, r, "hmac", reason, status)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *relayAuthenticator) allowedSource(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range a.cfg.AllowedCIDRs {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifySignature checks X-Relay-Signature, the HMAC-SHA256 of
+// X-Relay-Timestamp, a newline and the encapsulated body, and returns the
+// buffered body. Stale timestamps and reused signatures are rejected so a
+// captured request cannot be replayed.
+func (a *relayAuthenticator) verifySignature(r *http.Request) ([]byte, int, string) {
+	rawTimestamp := strings.TrimSpace(r.Header.Get(relayTimestampHeader))
+	unix, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	if err != nil {
+		return nil, http.StatusUnauthorized, "missing or malformed timestamp header (the bundled server-4 relay does not sign requests)"
+	}
+	now := a.now()
+	signedAt := time.Unix(unix, 0)
+	if signedAt.Before(now.Add(-a.cfg.MaxSignatureSkew)) || signedAt.After(now.Add(a.cfg.MaxSignatureSkew)) {
+		return nil, http.StatusUnauthorized, "timestamp outside allowed skew"
+	}
+
+	header := strings.TrimSpace(r.Header.Get(relaySignatureHeader))
+	if !strings.HasPrefix(header, relaySignaturePrefix) {
+		return nil, http.StatusUnauthorized, "missing or malformed signature header (the bundled server-4 relay does not sign requests)"
+	}
+	want, err := hex.DecodeString(strings.TrimPrefix(header, relaySignaturePrefix))
+	if err != nil {
+		return nil, http.StatusUnauthorized, "signature is not hex"
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, a.cfg.MaxSignedBodyBytes+1))
+	if err != nil {
+		return nil, http.StatusBadRequest, "failed to read body"
+	}
+	if int64(len(body)) > a.cfg.MaxSignedBodyBytes {
+		return nil, http.StatusRequestEntityTooLarge, "signed body too large"
+	}
+
+	mac := hmac.New(sha256.New, a.cfg.HMACSecret)
+	mac.Write([]byte(rawTimestamp + "\n"))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), want) {
+		return nil, http.StatusUnauthorized, "signature mismatch"
+	}
+	if !a.markSeen(string(want), signedAt.Add(a.cfg.MaxSignatureSkew), now) {
+		return nil, http.StatusUnauthorized, "signature already used"
+	}
+	return body, 0, ""
+}
+
+// markSeen records signature until expires and reports whether it was new.
+func (a *relayAuthenticator) markSeen(signature string, expires, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if now.Sub(a.lastPruned) > a.cfg.MaxSignatureSkew {
+		for seen, until := range a.seen {
+			if now.After(until) {
+				delete(a.seen, seen)
+			}
+		}
+		a.lastPruned = now
+	}
+	if _, ok := a.seen[signature]; ok {
+		return false
+	}
+	a.seen[signature] = expires
+	return true
+}
+
+// tlsConfig requires relays to present a certificate issued by ClientCAs.
+// Verification is done here rather than by crypto/tls so rejected handshakes
+// are counted.
+func (a *relayAuthenticator) tlsConfig() *tls.Config {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if a.cfg.ClientCAs == nil {
+		return cfg
+	}
+	cfg.ClientAuth = tls.RequestClientCert
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			a.metrics.inc("gateway_relay_auth_rejected_total", "method", "mtls")
+			return errors.New("relay client certificate required")
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range cs.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+			Roots:         a.cfg.ClientCAs,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		if err != nil {
+			a.metrics.inc("gateway_relay_auth_rejected_total", "method", "mtls")
+			slog.Warn("rejected relay certificate", "subject", cs.PeerCertificates[0].Subject.String(), "err", err)
+			return err
+		}
+		return nil
+	}
+	return cfg
+}
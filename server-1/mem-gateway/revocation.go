@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/circl/kem"
+	"github.com/openpcc/ohttp"
+	"github.com/openpcc/twoway"
+)
+
+const fingerprintPrefix = "sha256:"
+
+var errKeyRevoked = errors.New("key revoked")
+
+// revocationEntry revokes every key that matches all of its set fields.
+type revocationEntry struct {
+	KeyID       string `json:"key_id,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+
+	keyID    byte
+	hasKeyID bool
+}
+
+func (e revocationEntry) matches(keyID byte, fingerprint string) bool {
+	if e.hasKeyID && e.keyID != keyID {
+		return false
+	}
+	if e.Fingerprint != "" && e.Fingerprint != fingerprint {
+		return false
+	}
+	return true
+}
+
+type revocationList struct {
+	IssuedAt time.Time         `json:"issued_at"`
+	Revoked  []revocationEntry `json:"revoked"`
+
+	// payload is the signed JSON, to tell a re-sent list from a different
+	// list with the same issued_at.
+	payload []byte
+}
+
+// signedRevocationList carries the list JSON and its Ed25519 signature, both
+// base64 encoded, so the signed bytes are exactly the bytes that were parsed.
+type signedRevocationList struct {
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+func parseSignedRevocationList(raw []byte, publicKey ed25519.PublicKey) (*revocationList, error) {
+	var signed signedRevocationList
+	if err := json.Unmarshal(raw, &signed); err != nil {
+		return nil, fmt.Errorf("invalid envelope: %w", err)
+	}
+	payload, err := base64.StdEncoding.DecodeString(signed.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("payload is not base64: %w", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(signed.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("signature is not base64: %w", err)
+	}
+	if !ed25519.Verify(publicKey, payload, signature) {
+		return nil, errors.New("signature verification failed")
+	}
+
+	var list revocationList
+	if err := json.Unmarshal(payload, &list); err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+	if list.IssuedAt.IsZero() {
+		return nil, errors.New("issued_at is required")
+	}
+	list.payload = payload
+	for idx := range list.Revoked {
+		entry := &list.Revoked[idx]
+		if strings.TrimSpace(entry.KeyID) == "" && strings.TrimSpace(entry.Fingerprint) == "" {
+			return nil, fmt.Errorf("revoked[%d] needs key_id or fingerprint", idx)
+		}
+		if strings.TrimSpace(entry.KeyID) != "" {
+			keyID, err := parseKeyID(entry.KeyID)
+			if err != nil {
+				return nil, fmt.Errorf("revoked[%d].key_id invalid: %w", idx, err)
+			}
+			entry.keyID, entry.hasKeyID = keyID, true
+		}
+		if entry.Fingerprint != "" {
+			entry.Fingerprint = strings.ToLower(strings.TrimSpace(entry.Fingerprint))
+			digest, err := hex.DecodeString(strings.TrimPrefix(entry.Fingerprint, fingerprintPrefix))
+			if !strings.HasPrefix(entry.Fingerprint, fingerprintPrefix) || err != nil || len(digest) != sha256.Size {
+				return nil, fmt.Errorf("revoked[%d].fingerprint must be sha256:<64 hex digits>", idx)
+			}
+		}
+	}
+	return &list, nil
+}
+
+// keyFingerprint identifies a KEM public key independently of its key ID.
+func keyFingerprint(publicKey kem.PublicKey) (string, error) {
+	raw, err := publicKey.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return fingerprintPrefix + hex.EncodeToString(sum[:]), nil
+}
+
+// revocationStore holds the active revocation list. Without a public key it
+// is disabled and revokes nothing.
+type revocationStore struct {
+	publicKey ed25519.PublicKey
+	metrics   *metricsRegistry
+
+	mu   sync.RWMutex
+	list *revocationList
+}
+
+// loadRevocationStore reads GATEWAY_REVOCATION_PUBLIC_KEY (Ed25519, hex or
+// base64) and the initial GATEWAY_REVOCATION_LIST_FILE.
+func loadRevocationStore(metrics *metricsRegistry) (*revocationStore, string, time.Duration, error) {
+	store := &revocationStore{metrics: metrics}
+	path := strings.TrimSpace(os.Getenv("GATEWAY_REVOCATION_LIST_FILE"))
+	refresh, err := getenvDuration("GATEWAY_REVOCATION_REFRESH", 30*time.Second)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	rawKey := strings.TrimSpace(os.Getenv("GATEWAY_REVOCATION_PUBLIC_KEY"))
+	if rawKey == "" {
+		if path != "" {
+			return nil, "", 0, errors.New("GATEWAY_REVOCATION_LIST_FILE requires GATEWAY_REVOCATION_PUBLIC_KEY")
+		}
+		return store, "", 0, nil
+	}
+	publicKey, err := decodeEd25519PublicKey(rawKey)
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("GATEWAY_REVOCATION_PUBLIC_KEY invalid: %w", err)
+	}
+	store.publicKey = publicKey
+
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, "", 0, fmt.Errorf("failed to read GATEWAY_REVOCATION_LIST_FILE: %w", err)
+		}
+		if err := store.update(raw); err != nil {
+			return nil, "", 0, fmt.Errorf("revocation list %s rejected: %w", path, err)
+		}
+	}
+	return store, path, refresh, nil
+}
+
+func decodeEd25519PublicKey(raw string) (ed25519.PublicKey, error) {
+	decoded, err := hex.DecodeString(raw)
+	if err != nil {
+		decoded, err = base64.StdEncoding.DecodeString(raw)
+		if err != nil {
+			return nil, errors.New("not hex or base64")
+		}
+	}
+	if len(decoded) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("want %d bytes, got %d", ed25519.PublicKeySize, len(decoded))
+	}
+	return ed25519.PublicKey(decoded), nil
+}
+
+func (s *revocationStore) enabled() bool {
+	return s != nil && s.publicKey != nil
+}
+
+// update verifies and installs a signed list. Lists issued before the current
+// one are refused, so an old list cannot be replayed to un-revoke a key. A
+// list with the same issued_at is accepted only if it is the active list
+// again; different entries need a new issued_at.
+func (s *revocationStore) update(raw []byte) error {
+	if !s.enabled() {
+		return errors.New("revocation list not configured")
+	}
+	list, err := parseSignedRevocationList(raw, s.publicKey)
+	if err != nil {
+		s.metrics.inc("gateway_revocation_list_rejected_total")
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.list != nil {
+		if list.IssuedAt.Before(s.list.IssuedAt) {
+			s.metrics.inc("gateway_revocation_list_rejected_total")
+			return fmt.Errorf("list issued at %s is older than the active list (%s)", list.IssuedAt.Format(time.RFC3339), s.list.IssuedAt.Format(time.RFC3339))
+		}
+		if list.IssuedAt.Equal(s.list.IssuedAt) {
+			if bytes.Equal(list.payload, s.list.payload) {
+				return nil
+			}
+			s.metrics.inc("gateway_revocation_list_rejected_total")
+			return fmt.Errorf("list issued at %s differs from the active list with the same issued_at; sign it with a later issued_at", list.IssuedAt.Format(time.RFC3339))
+		}
+	}
+	s.list = list
+	s.metrics.set("gateway_revocation_list_entries", float64(len(list.Revoked)))
+	s.metrics.set("gateway_revocation_list_issued_timestamp_seconds", float64(list.IssuedAt.Unix()))
+	slog.Warn("revocation list installed", "issued_at", list.IssuedAt, "entries", len(list.Revoked))
+	return nil
+}
+
+func (s *revocationStore) revoked(keyID byte, fingerprint string) (revocationEntry, bool) {
+	if !s.enabled() {
+		return revocationEntry{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.list == nil {
+		return revocationEntry{}, false
+	}
+	for _, entry := range s.list.Revoked {
+		if entry.matches(keyID, fingerprint) {
+			return entry, true
+		}
+	}
+	return revocationEntry{}, false
+}
+
+// watch re-reads path every interval and installs changed lists.
+func (s *revocationStore) watch(ctx context.Context, path string, interval time.Duration) {
+	if path == "" || interval <= 0 {
+		return
+	}
+	var last []byte
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			slog.Error("failed to read revocation list", "path", path, "err", err)
+			continue
+		}
+		if bytes.Equal(raw, last) {
+			continue
+		}
+		if err := s.update(raw); err != nil {
+			slog.Error("revocation list rejected", "path", path, "err", err)
+		}
+		last = raw
+	}
+}
+
+// ServeHTTP implements the admin API: GET shows the active list and PUT
+// installs a signed list immediately.
+func (s *revocationStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.RLock()
+		list := s.list
+		s.mu.RUnlock()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"enabled": s.enabled(),
+			"list":    list,
+		})
+	case http.MethodPut:
+		if !s.enabled() {
+			http.Error(w, "revocation list not configured", http.StatusNotFound)
+			return
+		}
+		raw, err := readAdminBody(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.update(raw); err != nil {
+			http.Error(w, fmt.Sprintf("revocation list rejected: %v", err), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// revocationFinder refuses keys on the revocation list, checked on every
+// request so that a new list takes effect immediately.
+type revocationFinder struct {
+	next         ohttp.SecretKeyFinder
+	store        *revocationStore
+	fingerprints map[byte]string
+}
+
+func (f revocationFinder) FindSecretKey(ctx context.Context, header twoway.RequestHeader) (ohttp.SecretKeyInfo, error) {
+	info, err := f.next.FindSecretKey(ctx, header)
+	if err != nil {
+		return info, err
+	}
+	if entry, ok := f.store.revoked(info.KeyID, f.fingerprints[info.KeyID]); ok {
+		keyID := strconv.Itoa(int(info.KeyID))
+		f.store.metrics.inc("gateway_key_revoked_rejected_total", "key_id", keyID)
+		slog.WarnContext(ctx, "rejected revoked key", "key_id", keyID, "reason", entry.Reason)
+		return ohttp.SecretKeyInfo{}, errKeyRevoked
+	}
+	return info, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// keyRoute maps an oHTTP key ID to the router pool that should receive
+// requests encapsulated to that key.
+type keyRoute struct {
+	KeyID     byte
+	RouterURL string
+}
+
+// parseKeyRoutes parses GATEWAY_KEY_ROUTES, a comma separated list of
+// key_id=router_url pairs (for example "02=http://10.0.1.40:3600").
+func parseKeyRoutes(raw string) ([]keyRoute, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+
+	var routes []keyRoute
+	seen := map[byte]struct{}{}
+	for idx, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		rawID, routerURL, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(routerURL) == "" {
+			return nil, fmt.Errorf("route[%d] must be key_id=router_url", idx)
+		}
+		keyID, err := parseKeyID(rawID)
+		if err != nil {
+			return nil, fmt.Errorf("route[%d].key_id invalid: %w", idx, err)
+		}
+		if _, ok := seen[keyID]; ok {
+			return nil, fmt.Errorf("route[%d].key_id %d is duplicated", idx, keyID)
+		}
+		seen[keyID] = struct{}{}
+		routes = append(routes, keyRoute{KeyID: keyID, RouterURL: strings.TrimSpace(routerURL)})
+	}
+	return routes, nil
+}
+
+// gatewayOptions holds the process-wide settings shared by every router pool.
+type gatewayOptions struct {
+	BankURL          string
+	DefaultRouterURL string
+	Routes           []keyRoute
+	Breaker          breakerSettings
+	Metrics          *metricsRegistry
+	Faults           *faultInjector
+	InnerHeaders     *headerFilter
+	Revocations      *revocationStore
+}
+
+// keyRouter dispatches encapsulated requests to a gateway handler per router
+// pool. The key ID is the first byte of every oHTTP request header, so it can
+// be read before decapsulation without touching the rest of the body.
+type keyRouter struct {
+	pools       map[byte]http.Handler
+	defaultPool http.Handler
+}
+
+// newKeyRouter builds one upstream gateway per group and router URL pair.
+// Routes from GATEWAY_KEY_ROUTES override the router URL of a single key.
+// The default pool holds every key so that unknown key IDs still get the
+// regular oHTTP key errors.
+func newKeyRouter(groups []keyGroup, opts gatewayOptions) (http.Handler, error) {
+	if err := checkKeyIDCollisions(groups); err != nil {
+		return nil, err
+	}
+
+	breakers := map[string]*circuitBreaker{}
+	newPool := func(keys []gatewayKey, routerURL string) (http.Handler, error) {
+		breaker, ok := breakers[routerURL]
+		if !ok {
+			breaker = newCircuitBreaker(routerURL, opts.Breaker, opts.Metrics)
+			breakers[routerURL] = breaker
+		}
+		return newGatewayHandler(poolConfig{
+			Keys:         keys,
+			BankURL:      opts.BankURL,
+			RouterURL:    routerURL,
+			Metrics:      opts.Metrics,
+			Breaker:      breaker,
+			Faults:       opts.Faults,
+			InnerHeaders: opts.InnerHeaders,
+			Revocations:  opts.Revocations,
+		})
+	}
+
+	var allKeys []gatewayKey
+	for _, group := range groups {
+		allKeys = append(allKeys, group.Keys...)
+	}
+	defaultPool, err := newPool(allKeys, opts.DefaultRouterURL)
+	if err != nil {
+		return nil, err
+	}
+
+	routeByID := map[byte]string{}
+	for _, route := range opts.Routes {
+		routeByID[route.KeyID] = route.RouterURL
+	}
+	for keyID := range routeByID {
+		if !slices.ContainsFunc(allKeys, func(key gatewayKey) bool { return key.ID == keyID }) {
+			return nil, fmt.Errorf("route for key_id %d has no matching seed", keyID)
+		}
+	}
+
+	router := &keyRouter{
+		pools:       map[byte]http.Handler{},
+		defaultPool: defaultPool,
+	}
+	for _, group := range groups {
+		groupRouterURL := firstNonEmpty(group.RouterURL, opts.DefaultRouterURL)
+		keysByURL := map[string][]gatewayKey{}
+		for _, key := range group.Keys {
+			routerURL := firstNonEmpty(routeByID[key.ID], groupRouterURL)
+			keysByURL[routerURL] = append(keysByURL[routerURL], key)
+		}
+
+		limiter := newGroupLimiter(group.Name, group.Limits, opts.Metrics)
+		urls := make([]string, 0, len(keysByURL))
+		for routerURL := range keysByURL {
+			urls = append(urls, routerURL)
+		}
+		sort.Strings(urls)
+		for _, routerURL := range urls {
+			poolKeys := keysByURL[routerURL]
+			pool, err := newPool(poolKeys, routerURL)
+			if err != nil {
+				return nil, fmt.Errorf("group %q router pool %s: %w", group.Name, routerURL, err)
+			}
+			handler := limiter.wrap(pool)
+			for _, key := range poolKeys {
+				router.pools[key.ID] = handler
+				fingerprint, err := key.fingerprint()
+				if err != nil {
+					return nil, err
+				}
+				fmt.Fprintf(os.Stderr, "group %q: routing key_id %d (%s, %s) to router pool %s\n", group.Name, key.ID, key.Suite.Name, fingerprint, routerURL)
+			}
+		}
+	}
+	return router, nil
+}
+
+func checkKeyIDCollisions(groups []keyGroup) error {
+	owners := map[byte]string{}
+	for _, group := range groups {
+		for _, key := range group.Keys {
+			if owner, ok := owners[key.ID]; ok {
+				if owner == group.Name {
+					return fmt.Errorf("group %q: key_id %d is duplicated", group.Name, key.ID)
+				}
+				return fmt.Errorf("key_id %d is used by both group %q and group %q", key.ID, owner, group.Name)
+			}
+			owners[key.ID] = group.Name
+		}
+	}
+	return nil
+}
+
+func (k *keyRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.Body == nil {
+		k.defaultPool.ServeHTTP(w, r)
+		return
+	}
+
+	body := bufio.NewReader(r.Body)
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{body, r.Body}
+
+	head, err := body.Peek(1)
+	if err == nil {
+		if pool, ok := k.pools[head[0]]; ok {
+			pool.ServeHTTP(w, r)
+			return
+		}
+	}
+	k.defaultPool.ServeHTTP(w, r)
+}
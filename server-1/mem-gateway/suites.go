@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cloudflare/circl/hpke"
+	"github.com/openpcc/openpcc/gateway"
+)
+
+// hpkeSuite is a named KEM/KDF/AEAD combination that a seedSpec can select.
+type hpkeSuite struct {
+	Name string
+	KEM  hpke.KEM
+	KDF  hpke.KDF
+	AEAD hpke.AEAD
+}
+
+// defaultSuiteName is gateway.Suite, used when a seed does not name a suite.
+const defaultSuiteName = "x25519-kyber768-draft00"
+
+// hpkeSuites lists the supported suites, strongest first. Clients share the
+// same names and order.
+var hpkeSuites = []hpkeSuite{
+	{Name: "x25519-mlkem768", KEM: hpke.KEM_XWING, KDF: hpke.KDF_HKDF_SHA256, AEAD: hpke.AEAD_AES256GCM},
+	{Name: defaultSuiteName, KEM: hpke.KEM_X25519_KYBER768_DRAFT00, KDF: hpke.KDF_HKDF_SHA256, AEAD: hpke.AEAD_AES128GCM},
+	{Name: "x25519", KEM: hpke.KEM_X25519_HKDF_SHA256, KDF: hpke.KDF_HKDF_SHA256, AEAD: hpke.AEAD_AES128GCM},
+}
+
+func lookupSuite(name string) (hpkeSuite, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = defaultSuiteName
+	}
+	idx := slices.IndexFunc(hpkeSuites, func(s hpkeSuite) bool { return s.Name == name })
+	if idx == -1 {
+		names := make([]string, 0, len(hpkeSuites))
+		for _, s := range hpkeSuites {
+			names = append(names, s.Name)
+		}
+		return hpkeSuite{}, fmt.Errorf("unknown suite %q (supported: %s)", name, strings.Join(names, ", "))
+	}
+	return hpkeSuites[idx], nil
+}
+
+func defaultSuite() hpkeSuite {
+	kemID, kdfID, aeadID := gateway.Suite.Params()
+	return hpkeSuite{Name: defaultSuiteName, KEM: kemID, KDF: kdfID, AEAD: aeadID}
+}
@@ -2,9 +2,13 @@
 # Objective: Start OpenPCC oHTTP relay with upstream forwarding.
 # Usage examples:
 # - RELAY_UPSTREAM_GATEWAY_URL=http://10.0.1.23:3200 ./entrypoint.sh
+# - RELAY_UPSTREAM_GATEWAY_URL=https://10.0.1.23:3200 RELAY_UPSTREAM_CA_FILE=/certs/gateway-ca.pem \
+#   RELAY_UPSTREAM_CLIENT_CERT_FILE=/certs/relay.pem RELAY_UPSTREAM_CLIENT_KEY_FILE=/certs/relay-key.pem ./entrypoint.sh
 # Notes:
 # - ohttp-relay forwards to http://localhost:3200 (upstream default).
 # - This entrypoint binds localhost:3200 to the configured upstream gateway.
+# - An https:// upstream is reached over TLS, presenting the client certificate
+#   when set, for gateways that require relay mutual TLS.
 set -euo pipefail
 
 log() {
@@ -13,6 +17,9 @@ log() {
 
 RELAY_BIN="${RELAY_BIN:-/usr/local/bin/ohttp-relay}"
 RELAY_UPSTREAM_GATEWAY_URL="${RELAY_UPSTREAM_GATEWAY_URL:-}"
+RELAY_UPSTREAM_CA_FILE="${RELAY_UPSTREAM_CA_FILE:-}"
+RELAY_UPSTREAM_CLIENT_CERT_FILE="${RELAY_UPSTREAM_CLIENT_CERT_FILE:-}"
+RELAY_UPSTREAM_CLIENT_KEY_FILE="${RELAY_UPSTREAM_CLIENT_KEY_FILE:-}"
 
 if [[ ! -x "${RELAY_BIN}" ]]; then
   echo "Relay binary not found at ${RELAY_BIN}" >&2
@@ -24,12 +31,32 @@ if [[ -z "${RELAY_UPSTREAM_GATEWAY_URL}" ]]; then
   exit 1
 fi
 
-if [[ "${RELAY_UPSTREAM_GATEWAY_URL}" != http://* ]]; then
-  echo "RELAY_UPSTREAM_GATEWAY_URL must start with http:// (got ${RELAY_UPSTREAM_GATEWAY_URL})" >&2
+case "${RELAY_UPSTREAM_GATEWAY_URL}" in
+  http://*) upstream_scheme="http" ;;
+  https://*) upstream_scheme="https" ;;
+  *)
+    echo "RELAY_UPSTREAM_GATEWAY_URL must start with http:// or https:// (got ${RELAY_UPSTREAM_GATEWAY_URL})" >&2
+    exit 1
+    ;;
+esac
+
+if [[ -n "${RELAY_UPSTREAM_CLIENT_CERT_FILE}" || -n "${RELAY_UPSTREAM_CLIENT_KEY_FILE}" ]]; then
+  if [[ -z "${RELAY_UPSTREAM_CLIENT_CERT_FILE}" || -z "${RELAY_UPSTREAM_CLIENT_KEY_FILE}" ]]; then
+    echo "RELAY_UPSTREAM_CLIENT_CERT_FILE and RELAY_UPSTREAM_CLIENT_KEY_FILE must be set together" >&2
+    exit 1
+  fi
+  if [[ "${upstream_scheme}" != "https" ]]; then
+    echo "RELAY_UPSTREAM_CLIENT_CERT_FILE requires an https:// RELAY_UPSTREAM_GATEWAY_URL" >&2
+    exit 1
+  fi
+fi
+
+if [[ "${upstream_scheme}" == "https" && -z "${RELAY_UPSTREAM_CA_FILE}" ]]; then
+  echo "RELAY_UPSTREAM_CA_FILE is required for an https:// RELAY_UPSTREAM_GATEWAY_URL" >&2
   exit 1
 fi
 
-upstream_hostport="${RELAY_UPSTREAM_GATEWAY_URL#http://}"
+upstream_hostport="${RELAY_UPSTREAM_GATEWAY_URL#*://}"
 upstream_hostport="${upstream_hostport%%/*}"
 
 if [[ -z "${upstream_hostport}" || "${upstream_hostport}" != *:* ]]; then
@@ -45,15 +72,24 @@ if [[ -z "${upstream_host}" || -z "${upstream_port}" ]]; then
   exit 1
 fi
 
-if [[ "${upstream_host}" == "localhost" || "${upstream_host}" == "127.0.0.1" ]]; then
+if [[ "${upstream_scheme}" == "http" && ( "${upstream_host}" == "localhost" || "${upstream_host}" == "127.0.0.1" ) ]]; then
   if [[ "${upstream_port}" == "3200" ]]; then
     log "Upstream already localhost:3200; skipping local forward"
     exec "${RELAY_BIN}"
   fi
 fi
 
-log "Forwarding localhost:3200 to ${upstream_host}:${upstream_port}"
-socat TCP-LISTEN:3200,reuseaddr,fork TCP:"${upstream_host}":"${upstream_port}" &
+if [[ "${upstream_scheme}" == "https" ]]; then
+  upstream_addr="OPENSSL:${upstream_host}:${upstream_port},cafile=${RELAY_UPSTREAM_CA_FILE}"
+  if [[ -n "${RELAY_UPSTREAM_CLIENT_CERT_FILE}" ]]; then
+    upstream_addr+=",cert=${RELAY_UPSTREAM_CLIENT_CERT_FILE},key=${RELAY_UPSTREAM_CLIENT_KEY_FILE}"
+  fi
+  log "Forwarding localhost:3200 to ${upstream_host}:${upstream_port} over TLS"
+else
+  upstream_addr="TCP:${upstream_host}:${upstream_port}"
+  log "Forwarding localhost:3200 to ${upstream_host}:${upstream_port}"
+fi
+socat TCP-LISTEN:3200,reuseaddr,fork "${upstream_addr}" &
 forward_pid=$!
 log "Upstream forward running (pid=${forward_pid})"
 